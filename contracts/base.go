@@ -20,4 +20,7 @@ func (*EmptyBasePlugin) Ping(_ context.Context) error {
 type PluginBase interface {
 	// Ping makes sure the plugin is responsive.
 	Ping(ctx context.Context) error
-}
\ No newline at end of file
+}
+
+// Empty is the request type of contract endpoints that don't take a payload.
+type Empty struct{}
@@ -2,13 +2,20 @@ package contracts
 
 import "context"
 
+const (
+	// ProcessDataPath is the endpoint that serves DataProcessor.ProcessData.
+	ProcessDataPath = "/process"
+	// SupportedFormatsPath is the endpoint that serves DataProcessor.GetSupportedFormats.
+	SupportedFormatsPath = "/formats"
+)
+
 // DataProcessor is an example generic plugin contract for data processing.
 type DataProcessor interface {
 	PluginBase
-	
+
 	// ProcessData processes input data and returns the result.
 	ProcessData(ctx context.Context, input []byte) ([]byte, error)
-	
+
 	// GetSupportedFormats returns a list of data formats this plugin supports.
 	GetSupportedFormats(ctx context.Context) ([]string, error)
 }
@@ -22,7 +29,12 @@ type DataProcessorRequest struct {
 
 // DataProcessorResponse represents the response from data processing.
 type DataProcessorResponse struct {
-	Data     []byte `json:"data"`
-	Format   string `json:"format"`
+	Data     []byte                 `json:"data"`
+	Format   string                 `json:"format"`
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
-}
\ No newline at end of file
+}
+
+// SupportedFormatsResponse represents the list of formats a data processor supports.
+type SupportedFormatsResponse struct {
+	Formats []string `json:"formats"`
+}
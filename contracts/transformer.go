@@ -1,22 +1,36 @@
 package contracts
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+const (
+	// TransformPath is the endpoint that serves Transformer.Transform.
+	TransformPath = "/transform"
+	// TransformationsPath is the endpoint that serves Transformer.GetTransformations.
+	TransformationsPath = "/transformations"
+)
 
 // Transformer is a generic plugin contract for data transformation.
 type Transformer interface {
 	PluginBase
-	
+
 	// Transform applies a transformation to the input data.
 	Transform(ctx context.Context, request *TransformRequest) (*TransformResponse, error)
-	
+
 	// GetTransformations returns a list of available transformations.
 	GetTransformations(ctx context.Context) ([]TransformationInfo, error)
 }
 
 // TransformRequest represents a transformation request.
 type TransformRequest struct {
-	Data           []byte            `json:"data"`
-	Transformation string            `json:"transformation"`
+	Data           []byte                 `json:"data"`
+	Transformation string                 `json:"transformation"`
 	Parameters     map[string]interface{} `json:"parameters,omitempty"`
 }
 
@@ -28,9 +42,9 @@ type TransformResponse struct {
 
 // TransformationInfo describes an available transformation.
 type TransformationInfo struct {
-	Name        string                 `json:"name"`
-	Description string                 `json:"description"`
-	Parameters  []ParameterInfo        `json:"parameters,omitempty"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  []ParameterInfo `json:"parameters,omitempty"`
 }
 
 // ParameterInfo describes a transformation parameter.
@@ -40,4 +54,103 @@ type ParameterInfo struct {
 	Required    bool        `json:"required"`
 	Default     interface{} `json:"default,omitempty"`
 	Description string      `json:"description,omitempty"`
-}
\ No newline at end of file
+}
+
+// TransformationsResponse represents the list of transformations a transformer offers.
+type TransformationsResponse struct {
+	Transformations []TransformationInfo `json:"transformations"`
+}
+
+// Parameter types a transformation can declare in ParameterInfo.Type. They follow the JSON types.
+const (
+	ParameterTypeString  = "string"
+	ParameterTypeNumber  = "number"
+	ParameterTypeInteger = "integer"
+	ParameterTypeBoolean = "boolean"
+	ParameterTypeArray   = "array"
+	ParameterTypeObject  = "object"
+)
+
+// ValidateParameters checks the given parameters against the parameters declared by the transformation.
+// Required parameters must be present, every parameter must be declared and match its declared type.
+// The returned map contains the given parameters with the defaults of missing optional parameters filled in.
+func (t TransformationInfo) ValidateParameters(params map[string]interface{}) (map[string]interface{}, error) {
+	declared := make(map[string]ParameterInfo, len(t.Parameters))
+	for _, p := range t.Parameters {
+		declared[p.Name] = p
+	}
+
+	var errs []error
+	for name := range params {
+		if _, ok := declared[name]; !ok {
+			errs = append(errs, fmt.Errorf("unknown parameter %q", name))
+		}
+	}
+
+	result := make(map[string]interface{}, len(t.Parameters))
+	for _, p := range t.Parameters {
+		value, ok := params[p.Name]
+		switch {
+		case ok:
+			if !hasParameterType(value, p.Type) {
+				errs = append(errs, fmt.Errorf("parameter %q must be of type %s, got %T", p.Name, p.Type, value))
+				continue
+			}
+			result[p.Name] = value
+		case p.Required:
+			errs = append(errs, fmt.Errorf("missing required parameter %q", p.Name))
+		case p.Default != nil:
+			result[p.Name] = p.Default
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid parameters for transformation %q: %w", t.Name, errors.Join(errs...))
+	}
+
+	return result, nil
+}
+
+// hasParameterType reports whether value can be represented as the given JSON type. Values
+// decoded from JSON as well as native Go values are supported. An empty type accepts anything.
+func hasParameterType(value interface{}, typ string) bool {
+	if typ == "" {
+		return true
+	}
+
+	if value == nil {
+		return false
+	}
+
+	v := reflect.ValueOf(value)
+	switch typ {
+	case ParameterTypeString:
+		return v.Kind() == reflect.String
+	case ParameterTypeBoolean:
+		return v.Kind() == reflect.Bool
+	case ParameterTypeNumber:
+		return v.CanInt() || v.CanUint() || v.CanFloat() || isJSONNumber(value)
+	case ParameterTypeInteger:
+		switch {
+		case v.CanInt(), v.CanUint():
+			return true
+		case v.CanFloat():
+			return v.Float() == math.Trunc(v.Float())
+		case isJSONNumber(value):
+			_, err := value.(json.Number).Int64()
+			return err == nil
+		}
+		return false
+	case ParameterTypeArray:
+		return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+	case ParameterTypeObject:
+		return v.Kind() == reflect.Map || v.Kind() == reflect.Struct
+	default:
+		return false
+	}
+}
+
+func isJSONNumber(value interface{}) bool {
+	_, ok := value.(json.Number)
+	return ok
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -10,49 +9,8 @@ import (
 
 	"github.com/Skarlso/go-plugin-framework/contracts"
 	"github.com/Skarlso/go-plugin-framework/manager"
-	"github.com/Skarlso/go-plugin-framework/registry/plugins"
 )
 
-// DataProcessorClient wraps external plugin calls to implement the DataProcessor interface.
-type DataProcessorClient struct {
-	wrapper    *manager.ExternalPluginWrapper // This would need to be accessible from manager package
-	pluginType string
-}
-
-func (dpc *DataProcessorClient) Ping(ctx context.Context) error {
-	return dpc.wrapper.Ping(ctx)
-}
-
-func (dpc *DataProcessorClient) ProcessData(ctx context.Context, input []byte) ([]byte, error) {
-	request := contracts.DataProcessorRequest{
-		Data:   input,
-		Format: "text/plain",
-	}
-
-	var response contracts.DataProcessorResponse
-	err := dpc.wrapper.CallPlugin(ctx, "/process", "POST",
-		plugins.WithPayload(request),
-		plugins.WithResult(&response),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to call plugin: %w", err)
-	}
-
-	return response.Data, nil
-}
-
-func (dpc *DataProcessorClient) GetSupportedFormats(ctx context.Context) ([]string, error) {
-	var response map[string][]string
-	err := dpc.wrapper.CallPlugin(ctx, "/formats", "GET",
-		plugins.WithResult(&response),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to call plugin: %w", err)
-	}
-
-	return response["formats"], nil
-}
-
 func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
@@ -79,7 +37,7 @@ func main() {
 	logger.Info("Looking for plugins", "directory", pluginDir)
 
 	// Register plugins from directory
-	err := pm.RegisterPlugins(ctx, pluginDir,
+	report, err := pm.RegisterPlugins(ctx, pluginDir,
 		manager.WithIdleTimeout(5*time.Minute),
 		manager.WithPluginFilter(func(name string) bool {
 			// Only load plugins that start with "simple-"
@@ -92,8 +50,12 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Example: Get a data processor plugin
-	plugin, err := pm.GetPlugin(ctx, "dataProcessor")
+	for _, failed := range append(report.Refused(), report.Failed()...) {
+		logger.Warn("plugin was not registered", "id", failed.ID, "status", failed.Status, "error", failed.Err)
+	}
+
+	// Example: Get a data processor plugin. It doesn't matter if it is an internal or an external plugin.
+	plugin, err := manager.GetTyped[contracts.DataProcessor](ctx, pm, "dataProcessor")
 	if err != nil {
 		logger.Error("failed to get data processor plugin", "error", err)
 		os.Exit(1)
@@ -109,34 +71,63 @@ func main() {
 	}
 	logger.Info("Plugin is responsive")
 
-	// If it's an external plugin, we need to use the wrapper to call specific methods
-	if wrapper, ok := plugin.(*manager.ExternalPluginWrapper); ok {
-		client := &DataProcessorClient{wrapper: wrapper, pluginType: "dataProcessor"}
+	// Test data processing
+	testData := []byte("hello world!")
+	result, err := plugin.ProcessData(ctx, testData)
+	if err != nil {
+		logger.Error("failed to process data", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Processing result", "input", string(testData), "output", string(result))
+
+	// Test getting supported formats
+	formats, err := plugin.GetSupportedFormats(ctx)
+	if err != nil {
+		logger.Error("failed to get supported formats", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Supported formats", "formats", formats)
 
-		// Test data processing
-		testData := []byte("hello world!")
-		result, err := client.ProcessData(ctx, testData)
+	// Example: Use a transformer plugin if one is available
+	transformer, err := manager.GetTyped[contracts.Transformer](ctx, pm, "transformer")
+	if err == nil {
+		transformations, err := transformer.GetTransformations(ctx)
 		if err != nil {
-			logger.Error("failed to process data", "error", err)
+			logger.Error("failed to get transformations", "error", err)
 			os.Exit(1)
 		}
 
-		logger.Info("Processing result", "input", string(testData), "output", string(result))
+		logger.Info("Available transformations", "transformations", transformations)
 
-		// Test getting supported formats
-		formats, err := client.GetSupportedFormats(ctx)
+		// Parameters are validated against the declared transformation before the call is made.
+		// The missing "times" parameter is filled in with its default.
+		response, err := transformer.Transform(ctx, &contracts.TransformRequest{
+			Data:           testData,
+			Transformation: "repeat",
+			Parameters:     map[string]interface{}{"separator": " "},
+		})
 		if err != nil {
-			logger.Error("failed to get supported formats", "error", err)
+			logger.Error("failed to transform data", "error", err)
 			os.Exit(1)
 		}
 
-		logger.Info("Supported formats", "formats", formats)
+		logger.Info("Transformation result", "input", string(testData), "output", string(response.Data))
 	}
 
 	// Cleanup
-	if err := pm.Shutdown(ctx); err != nil {
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	results, err := pm.Shutdown(shutdownCtx)
+	if err != nil {
 		logger.Error("failed to shutdown plugin manager", "error", err)
 	}
 
+	for _, result := range results {
+		logger.Info("plugin stopped", "id", result.ID, "step", result.Step, "exit-code", result.ExitCode)
+	}
+
 	logger.Info("Example completed successfully")
 }
@@ -3,10 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
 	"strings"
 
@@ -32,83 +32,29 @@ func (sp *SimpleProcessor) GetSupportedFormats(ctx context.Context) ([]string, e
 	return []string{"text/plain", "text"}, nil
 }
 
-var logger *slog.Logger
-
-// HTTP handlers for the plugin endpoints
-func (sp *SimpleProcessor) handleProcessData(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req contracts.DataProcessorRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	result, err := sp.ProcessData(r.Context(), req.Data)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	response := contracts.DataProcessorResponse{
-		Data:   result,
-		Format: req.Format,
-		Metadata: map[string]interface{}{
-			"processed_by": "simple-processor",
-			"operation":    "uppercase",
-		},
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		logger.ErrorContext(r.Context(), "failed to encode response", "error", err)
-	}
-}
-
-func (sp *SimpleProcessor) handleGetFormats(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	formats, err := sp.GetSupportedFormats(r.Context())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string][]string{"formats": formats}); err != nil {
-		logger.ErrorContext(r.Context(), "failed to encode response", "error", err)
-	}
-}
-
 func main() {
 	args := os.Args[1:]
 	// log messages are shared over stderr by convention established by the plugin manager.
-	logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
 		Level: slog.LevelDebug,
 	}))
 
 	processor := &SimpleProcessor{}
 
-	// Handle capabilities request
-	if len(args) > 0 && args[0] == "capabilities" {
-		capabilities := types.PluginCapabilities{
-			Types: map[string][]types.TypeInfo{
-				"dataProcessor": {
-					{
-						Type:       "simple-text-processor",
-						JSONSchema: []byte(`{"type": "object", "properties": {"format": {"type": "string"}}}`),
-					},
+	capabilities := types.PluginCapabilities{
+		Types: map[string][]types.TypeInfo{
+			"dataProcessor": {
+				{
+					Type:       "simple-text-processor",
+					JSONSchema: []byte(`{"type": "object", "properties": {"format": {"type": "string"}}}`),
 				},
 			},
-			ConfigTypes: []string{}, // This plugin doesn't require specific config
-		}
+		},
+		ConfigTypes: []string{}, // This plugin doesn't require specific config
+	}
 
+	// Handle capabilities request
+	if len(args) > 0 && args[0] == "capabilities" {
 		content, err := json.Marshal(capabilities)
 		if err != nil {
 			logger.Error("failed to marshal capabilities", "error", err)
@@ -147,20 +93,10 @@ func main() {
 	// Create the plugin
 	ctx := context.Background()
 	plugin := sdk.NewPlugin(ctx, logger, conf, os.Stdout)
+	plugin.Capabilities = &capabilities
 
-	// Register HTTP handlers
-	handlers := []sdk.Handler{
-		{
-			Location: "/process",
-			Handler:  processor.handleProcessData,
-		},
-		{
-			Location: "/formats",
-			Handler:  processor.handleGetFormats,
-		},
-	}
-
-	if err := plugin.RegisterHandlers(handlers...); err != nil {
+	// Register the HTTP handlers of the DataProcessor contract
+	if err := plugin.RegisterHandlers(sdk.ServeDataProcessor(processor)...); err != nil {
 		logger.Error("failed to register handlers", "error", err)
 		os.Exit(1)
 	}
@@ -168,6 +104,11 @@ func main() {
 	logger.Info("starting up plugin", "plugin", conf.ID)
 
 	if err := plugin.Start(ctx); err != nil {
+		if errors.Is(err, sdk.ErrIdleTimeout) {
+			// let the manager know that it can start the plugin again once it's needed
+			os.Exit(types.IdleExitCode)
+		}
+
 		logger.Error("failed to start plugin", "error", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Skarlso/go-plugin-framework/contracts"
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+	"github.com/Skarlso/go-plugin-framework/sdk"
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// SimpleTransformer is an example transformer plugin.
+type SimpleTransformer struct {
+	contracts.EmptyBasePlugin
+}
+
+// GetTransformations returns the transformations this plugin offers.
+func (st *SimpleTransformer) GetTransformations(ctx context.Context) ([]contracts.TransformationInfo, error) {
+	return []contracts.TransformationInfo{
+		{
+			Name:        "reverse",
+			Description: "Reverses the input data.",
+		},
+		{
+			Name:        "repeat",
+			Description: "Repeats the input data.",
+			Parameters: []contracts.ParameterInfo{
+				{
+					Name:        "times",
+					Type:        contracts.ParameterTypeInteger,
+					Default:     2,
+					Description: "How many times the input is repeated.",
+				},
+				{
+					Name:        "separator",
+					Type:        contracts.ParameterTypeString,
+					Description: "Separator placed between the repetitions.",
+				},
+			},
+		},
+	}, nil
+}
+
+// Transform applies the requested transformation.
+func (st *SimpleTransformer) Transform(ctx context.Context, request *contracts.TransformRequest) (*contracts.TransformResponse, error) {
+	switch request.Transformation {
+	case "reverse":
+		data := slices.Clone(request.Data)
+		slices.Reverse(data)
+
+		return &contracts.TransformResponse{Data: data}, nil
+	case "repeat":
+		// numbers are decoded as float64 from the JSON request
+		times, _ := request.Parameters["times"].(float64)
+		separator, _ := request.Parameters["separator"].(string)
+
+		parts := make([]string, int(times))
+		for i := range parts {
+			parts[i] = string(request.Data)
+		}
+
+		return &contracts.TransformResponse{
+			Data:     []byte(strings.Join(parts, separator)),
+			Metadata: map[string]interface{}{"repetitions": len(parts)},
+		}, nil
+	default:
+		return nil, plugins.NewError(fmt.Errorf("unknown transformation %q", request.Transformation), http.StatusBadRequest)
+	}
+}
+
+func main() {
+	args := os.Args[1:]
+	// log messages are shared over stderr by convention established by the plugin manager.
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+
+	transformer := &SimpleTransformer{}
+
+	capabilities := types.PluginCapabilities{
+		Types: map[string][]types.TypeInfo{
+			"transformer": {
+				{
+					Type:       "simple-transformer",
+					JSONSchema: []byte(`{"type": "object", "properties": {"transformation": {"type": "string"}}, "required": ["transformation"]}`),
+				},
+			},
+		},
+	}
+
+	// Handle capabilities request
+	if len(args) > 0 && args[0] == "capabilities" {
+		content, err := json.Marshal(capabilities)
+		if err != nil {
+			logger.Error("failed to marshal capabilities", "error", err)
+			os.Exit(1)
+		}
+
+		if _, err := fmt.Fprintln(os.Stdout, string(content)); err != nil {
+			logger.Error("failed print capabilities", "error", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	// Parse command-line arguments
+	configData := flag.String("config", "", "Plugin config.")
+	flag.Parse()
+	if configData == nil || *configData == "" {
+		logger.Error("missing required flag --config")
+		os.Exit(1)
+	}
+
+	conf := types.Config{}
+	if err := json.Unmarshal([]byte(*configData), &conf); err != nil {
+		logger.Error("failed to unmarshal config", "error", err)
+		os.Exit(1)
+	}
+
+	if conf.ID == "" {
+		logger.Error("plugin config has no ID")
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	plugin := sdk.NewPlugin(ctx, logger, conf, os.Stdout)
+	plugin.Capabilities = &capabilities
+
+	// Register the HTTP handlers of the Transformer contract
+	if err := plugin.RegisterHandlers(sdk.ServeTransformer(transformer)...); err != nil {
+		logger.Error("failed to register handlers", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("starting up plugin", "plugin", conf.ID)
+
+	if err := plugin.Start(ctx); err != nil {
+		if errors.Is(err, sdk.ErrIdleTimeout) {
+			// let the manager know that it can start the plugin again once it's needed
+			os.Exit(types.IdleExitCode)
+		}
+
+		logger.Error("failed to start plugin", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,24 @@
+// Package concurrency holds the helpers shared by the manager and the registry to run work concurrently.
+package concurrency
+
+import "sync"
+
+// ForEach calls fn for every index below n with at most limit calls running at the same time.
+// A limit below 1 runs the calls one after another. ForEach returns once all calls returned.
+func ForEach(n, limit int, fn func(i int)) {
+	sem := make(chan struct{}, max(limit, 1))
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,31 @@
+package concurrency
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEach(t *testing.T) {
+	var running, peak atomic.Int32
+	called := make([]bool, 10)
+	ForEach(len(called), 3, func(i int) {
+		current := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		called[i] = true
+	})
+
+	require.Equal(t, []bool{true, true, true, true, true, true, true, true, true, true}, called)
+	require.LessOrEqual(t, peak.Load(), int32(3))
+}
@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/contracts"
+	"github.com/Skarlso/go-plugin-framework/registry"
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+func TestExamplePluginEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the example plugin")
+	}
+
+	dir := t.TempDir()
+	build := exec.Command("go", "build", "-o", filepath.Join(dir, "simple-processor"), "../examples/simple-processor")
+	output, err := build.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	pm := NewPluginManager(t.Context())
+	report, err := pm.RegisterPlugins(t.Context(), dir, WithStartupTimeout(10*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, StatusRegistered, report.Plugins[0].Status)
+
+	plugin, err := GetTyped[contracts.DataProcessor](t.Context(), pm, "dataProcessor")
+	require.NoError(t, err)
+	result, err := plugin.ProcessData(t.Context(), []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "HELLO", string(result))
+
+	// The plugin's logs end up in the host's logging instead of breaking the handshake.
+	lines, err := pm.PluginOutput("simple-processor")
+	require.NoError(t, err)
+	require.NotEmpty(t, lines)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	results, err := pm.Shutdown(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []registry.ShutdownResult{{ID: "simple-processor", Step: registry.StepEndpoint, ExitCode: 0}}, results)
+
+	// The same plugin serves mutual TLS over TCP with a certificate issued for it.
+	ca, err := plugins.NewCertificateAuthority()
+	require.NoError(t, err)
+	reg := registry.NewRegistry(t.Context())
+	require.NoError(t, reg.AddExternalPlugin(types.Plugin{
+		ID:     "simple-processor-tls",
+		Path:   filepath.Join(dir, "simple-processor"),
+		Config: types.Config{ID: "simple-processor-tls", Type: types.TCP},
+		Types:  map[string][]types.TypeInfo{"dataProcessor": {{Type: "simple-text-processor"}}},
+	}, registry.WithTLS(ca), registry.WithStartupTimeout(10*time.Second)))
+
+	plugin, err = registry.GetTyped[contracts.DataProcessor](t.Context(), reg, "dataProcessor")
+	require.NoError(t, err)
+	result, err = plugin.ProcessData(t.Context(), []byte("tls"))
+	require.NoError(t, err)
+	require.Equal(t, "TLS", string(result))
+
+	results, err = reg.Shutdown(ctx)
+	require.NoError(t, err)
+	require.Equal(t, registry.StepEndpoint, results[0].Step)
+}
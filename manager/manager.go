@@ -3,19 +3,24 @@ package manager
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Skarlso/go-plugin-framework/contracts"
+	"github.com/Skarlso/go-plugin-framework/internal/concurrency"
 	"github.com/Skarlso/go-plugin-framework/registry"
 	"github.com/Skarlso/go-plugin-framework/registry/plugins"
 	"github.com/Skarlso/go-plugin-framework/types"
@@ -30,28 +35,74 @@ type PluginManager struct {
 	Registry *registry.Registry
 
 	mu sync.Mutex
+	// syncMu serializes the passes of Watch, which only hold mu while they compare files.
+	syncMu sync.Mutex
+
+	// files holds the plugin files RegisterPlugins and Watch looked at, by their absolute path.
+	files map[string]*pluginFile
 
 	// baseCtx is the context that is used for all plugins.
 	// This is a different context than the one used for fetching plugins because
 	// that context is done once fetching is done. The plugin context, however, must not
 	// be cancelled.
 	baseCtx context.Context
+
+	// sharedMu guards what the manager creates once for all registrations.
+	sharedMu sync.Mutex
+	// ca is the certificate authority created for the first registration that uses WithTLS.
+	ca *plugins.CertificateAuthority
+	// runtimeDirs holds the private runtime directories of the manager.
+	runtimeDirs map[runtimeDirKey]string
 }
 
 // NewPluginManager initializes the PluginManager
 // the passed ctx is used for all plugins.
 func NewPluginManager(ctx context.Context) *PluginManager {
 	return &PluginManager{
-		Registry: registry.NewRegistry(ctx),
-		baseCtx:  ctx,
+		Registry:    registry.NewRegistry(ctx),
+		files:       make(map[string]*pluginFile),
+		baseCtx:     ctx,
+		runtimeDirs: make(map[runtimeDirKey]string),
 	}
 }
 
 // RegistrationOptions holds configuration for plugin registration.
 type RegistrationOptions struct {
-	IdleTimeout  time.Duration
-	ConfigData   []types.ConfigData
-	PluginFilter func(string) bool // Filter function to decide which plugins to register
+	IdleTimeout   time.Duration
+	ConfigData    []types.ConfigData
+	PluginFilter  func(string) bool // Filter function to decide which plugins to register
+	RestartPolicy registry.RestartPolicy
+	// OnDemand registers plugins without starting them. They are started on first use.
+	OnDemand bool
+	// Verification checks plugin binaries before they are executed. Nil disables verification.
+	Verification *Verification
+	// Strict stops the registration at the first plugin that is refused or fails and returns an error.
+	Strict bool
+	// StartupConcurrency is the number of plugins that are described and started at the same time.
+	StartupConcurrency int
+	// PollInterval is how often Watch scans the plugin directory.
+	PollInterval time.Duration
+	// DrainTimeout bounds how long Watch waits for in-flight calls before stopping a removed or replaced plugin.
+	DrainTimeout time.Duration
+	// Credential runs the plugin processes as a different user and group. Nil runs them as the host's user.
+	Credential *registry.Credential
+	// Resources limits the resources of every plugin process. Limits declared in a plugin's manifest
+	// can only make them stricter.
+	Resources *types.ResourceLimits
+	// CgroupParent is a cgroup v2 directory the plugin processes get their own cgroup in.
+	CgroupParent string
+	// StartupTimeout bounds the wait for a plugin process to output its handshake and pass its health check.
+	StartupTimeout time.Duration
+	// OutputLogger logs the output of the plugin processes. Defaults to the default slog logger.
+	OutputLogger *slog.Logger
+	// TLS secures the connections to TCP plugins with mutual TLS.
+	TLS bool
+	// ConnectionType is the connection type of the plugins. Defaults to abstract sockets on Linux,
+	// Unix sockets if they are available and TCP otherwise.
+	ConnectionType types.ConnectionType
+	// RuntimeDir is the directory the private runtime directory of the manager is created in.
+	// Defaults to $XDG_RUNTIME_DIR or, if that isn't set, the temporary directory.
+	RuntimeDir string
 }
 
 // RegistrationOptionFn is a function that configures RegistrationOptions.
@@ -78,81 +129,557 @@ func WithPluginFilter(filter func(string) bool) RegistrationOptionFn {
 	}
 }
 
+// WithRestartPolicy configures if and how plugins are restarted after their process exits.
+func WithRestartPolicy(policy registry.RestartPolicy) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.RestartPolicy = policy
+	}
+}
+
+// WithOnDemand registers plugins from their capabilities without starting them. A plugin is
+// started by the first GetPlugin or call to it and started again if it stopped because of
+// its idle timeout.
+func WithOnDemand() RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.OnDemand = true
+	}
+}
+
+// WithVerification verifies every plugin binary against a checksum allowlist and/or detached
+// ed25519 signatures before it is executed, including for the capabilities command. Binaries
+// that fail verification are reported as refused and never run.
+func WithVerification(verification Verification) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.Verification = &verification
+	}
+}
+
+// WithStrict makes any refused or failed plugin fatal. RegisterPlugins stops at the first such plugin
+// and returns ErrRegistrationFailed together with the report. Plugins registered before the failure
+// stay registered.
+func WithStrict() RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.Strict = true
+	}
+}
+
+// WithStartupConcurrency verifies, describes and starts up to n plugins at the same time.
+// Plugins are still registered in the order of their paths, so conflicts between them are
+// resolved the same way regardless of n. Defaults to 1.
+func WithStartupConcurrency(n int) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.StartupConcurrency = n
+	}
+}
+
+// WithCredential runs the plugin processes as the given user and group. The host needs the
+// privileges to switch to them. It's only supported on Linux.
+func WithCredential(uid, gid uint32) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.Credential = &registry.Credential{UID: uid, GID: gid}
+	}
+}
+
+// WithResourceLimits limits the resources of every plugin process. A plugin's manifest may
+// declare stricter limits, but not looser ones. Limits are only supported on Linux.
+func WithResourceLimits(limits types.ResourceLimits) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.Resources = &limits
+	}
+}
+
+// WithCgroupParent runs every plugin process in its own cgroup below the given cgroup v2
+// directory, so the memory, CPU and process limits apply to the plugin and all its subprocesses.
+// The directory has to be writable by the host; if it isn't, only the rlimits are applied.
+func WithCgroupParent(dir string) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.CgroupParent = dir
+	}
+}
+
+// WithStartupTimeout bounds the wait for every new plugin process to output its handshake and
+// pass its health check. Processes that aren't ready in time are killed. Defaults to 30 seconds.
+func WithStartupTimeout(d time.Duration) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.StartupTimeout = d
+	}
+}
+
+// WithOutputLogger logs what the plugin processes write to stdout and stderr with logger instead
+// of the default slog logger.
+func WithOutputLogger(logger *slog.Logger) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.OutputLogger = logger
+	}
+}
+
+// WithTLS secures the connections to TCP plugins with mutual TLS. The manager creates an
+// ephemeral certificate authority with locally generated keys, issues a server certificate for
+// every plugin process, which is pinned when connecting to it, and authenticates with a client
+// certificate of its own. TLS implies TCP connections unless WithConnectionType sets another
+// connection type, in which case registration fails.
+func WithTLS() RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.TLS = true
+	}
+}
+
+// WithConnectionType connects to the plugins with the given connection type instead of the
+// preferred one available. Plugins built with SDKs that don't support abstract sockets need
+// types.Socket or types.TCP on Linux.
+func WithConnectionType(t types.ConnectionType) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.ConnectionType = t
+	}
+}
+
 // RegisterPlugins walks through files in a folder and registers them
-// as plugins if connection points can be established. This function doesn't support
-// concurrent access.
-func (pm *PluginManager) RegisterPlugins(ctx context.Context, dir string, opts ...RegistrationOptionFn) error {
+// as plugins if connection points can be established. The returned report lists
+// the outcome for every discovered plugin file. If none of the plugins could be
+// registered, the report is returned together with ErrNoPluginsRegistered.
+// The deadline of ctx bounds the whole registration, including waiting for the
+// plugins to start. This function doesn't support concurrent access.
+func (pm *PluginManager) RegisterPlugins(ctx context.Context, dir string, opts ...RegistrationOptionFn) (*RegistrationReport, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
+	reg, err := pm.newRegistration(opts)
+	if err != nil {
+		return nil, err
+	}
+	reg.dir = dir
+
+	plugins, err := pm.fetchPlugins(ctx, &reg.conf, dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch plugins: %w", err)
+	}
+
+	return pm.register(ctx, reg, plugins)
+}
+
+// registration holds everything shared by the plugins registered with the same options.
+type registration struct {
+	opts   *RegistrationOptions
+	conf   types.Config
+	verify *verifier
+	// dir is the plugin directory the plugins are discovered in.
+	dir string
+	// runtimeDir is the private runtime directory of the plugins. It's only set if the plugins
+	// use sockets or are verified, in which case the verified binaries are copied to it.
+	runtimeDir string
+	// ca issues the TLS certificates of the plugins if the registration uses WithTLS.
+	ca *plugins.CertificateAuthority
+}
+
+// newRegistration creates a registration that uses the certificate authority and the runtime
+// directory of the manager.
+func (pm *PluginManager) newRegistration(opts []RegistrationOptionFn) (*registration, error) {
+	reg, err := newRegistration(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if reg.opts.TLS {
+		if reg.ca, err = pm.certificateAuthority(); err != nil {
+			return nil, err
+		}
+	}
+
+	if reg.conf.Type == types.Socket || reg.verify != nil {
+		if reg.runtimeDir, err = pm.runtimeDir(reg.opts.RuntimeDir, reg.opts.Credential); err != nil {
+			return nil, err
+		}
+	}
+	if reg.conf.Type == types.Socket {
+		reg.conf.RuntimeDir = reg.runtimeDir
+	}
+
+	return reg, nil
+}
+
+// certificateAuthority returns the certificate authority of the manager, creating it first if needed.
+func (pm *PluginManager) certificateAuthority() (*plugins.CertificateAuthority, error) {
+	pm.sharedMu.Lock()
+	defer pm.sharedMu.Unlock()
+
+	if pm.ca == nil {
+		ca, err := plugins.NewCertificateAuthority()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the plugin certificate authority: %w", err)
+		}
+		pm.ca = ca
+	}
+
+	return pm.ca, nil
+}
+
+func newRegistration(opts []RegistrationOptionFn) (*registration, error) {
 	defaultOpts := &RegistrationOptions{
 		IdleTimeout:  time.Hour,
 		PluginFilter: func(string) bool { return true }, // Accept all plugins by default
+		RestartPolicy: registry.RestartPolicy{
+			Mode: registry.RestartNever,
+		},
+		StartupConcurrency: 1,
+		PollInterval:       2 * time.Second,
+		DrainTimeout:       30 * time.Second,
 	}
 
 	for _, opt := range opts {
 		opt(defaultOpts)
 	}
 
-	conf := &types.Config{
-		IdleTimeout: &defaultOpts.IdleTimeout,
-		ConfigTypes: defaultOpts.ConfigData,
+	reg := &registration{
+		opts: defaultOpts,
+		conf: types.Config{
+			IdleTimeout: &defaultOpts.IdleTimeout,
+			ConfigTypes: defaultOpts.ConfigData,
+		},
 	}
 
-	t, err := determineConnectionType()
-	if err != nil {
-		return fmt.Errorf("could not determine connection type: %w", err)
+	if defaultOpts.Verification != nil {
+		v, err := newVerifier(defaultOpts.Verification)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification configuration: %w", err)
+		}
+		reg.verify = v
 	}
-	conf.Type = t
 
-	plugins, err := pm.fetchPlugins(ctx, conf, dir, defaultOpts.PluginFilter)
-	if err != nil {
-		return fmt.Errorf("could not fetch plugins: %w", err)
+	t := defaultOpts.ConnectionType
+	if defaultOpts.TLS {
+		if t != "" && t != types.TCP {
+			return nil, fmt.Errorf("TLS is only supported for TCP connections, not for connection type %q", t)
+		}
+		t = types.TCP
 	}
 
-	if len(plugins) == 0 {
-		return ErrNoPluginsFound
+	switch t {
+	case "":
+		var err error
+		if t, err = determineConnectionType(); err != nil {
+			return nil, fmt.Errorf("could not determine connection type: %w", err)
+		}
+	case types.AbstractSocket:
+		if !plugins.AbstractSocketsSupported {
+			return nil, fmt.Errorf("connection type %q is only supported on Linux", t)
+		}
+	case types.Socket, types.TCP:
+	default:
+		return nil, fmt.Errorf("unknown connection type %q", t)
+	}
+	reg.conf.Type = t
+
+	return reg, nil
+}
+
+// pluginOptions returns the registry options for the plugins of the registration.
+func (reg *registration) pluginOptions() []registry.ExternalPluginOptionFn {
+	pluginOpts := []registry.ExternalPluginOptionFn{registry.WithRestartPolicy(reg.opts.RestartPolicy)}
+	if reg.opts.OnDemand {
+		pluginOpts = append(pluginOpts, registry.WithLazyStart())
+	}
+	if credential := reg.opts.Credential; credential != nil {
+		pluginOpts = append(pluginOpts, registry.WithCredential(credential.UID, credential.GID))
+	}
+	if reg.opts.CgroupParent != "" {
+		pluginOpts = append(pluginOpts, registry.WithCgroupParent(reg.opts.CgroupParent))
+	}
+	if reg.opts.StartupTimeout > 0 {
+		pluginOpts = append(pluginOpts, registry.WithStartupTimeout(reg.opts.StartupTimeout))
+	}
+	if reg.ca != nil {
+		pluginOpts = append(pluginOpts, registry.WithTLS(reg.ca))
+	}
+	if reg.opts.OutputLogger != nil {
+		pluginOpts = append(pluginOpts, registry.WithOutputLogger(reg.opts.OutputLogger))
 	}
 
+	return pluginOpts
+}
+
+// register verifies, describes and registers the discovered plugins and reports the outcome.
+// The caller has to hold pm.mu.
+func (pm *PluginManager) register(ctx context.Context, reg *registration, plugins []*types.Plugin) (*RegistrationReport, error) {
+	report := &RegistrationReport{}
 	for _, plugin := range plugins {
-		conf.ID = plugin.ID
-		plugin.Config = *conf
+		status := StatusDiscovered
+		if !reg.opts.PluginFilter(plugin.ID) {
+			slog.DebugContext(ctx, "skipping plugin due to filter", "id", plugin.ID, "path", plugin.Path)
+			status = StatusFiltered
+		}
+
+		report.Plugins = append(report.Plugins, PluginReport{ID: plugin.ID, Path: plugin.Path, Status: status})
+	}
+
+	if len(report.withStatus(StatusDiscovered)) == 0 {
+		return report, ErrNoPluginsFound
+	}
+
+	// Verify and describe the plugins concurrently. In strict mode, no new plugin is
+	// described once one failed.
+	var failed atomic.Bool
+	capabilities := make([]*types.PluginCapabilities, len(plugins))
+	versions := make([]fileVersion, len(plugins))
+	concurrency.ForEach(len(plugins), reg.opts.StartupConcurrency, func(i int) {
+		if report.Plugins[i].Status != StatusDiscovered || (reg.opts.Strict && failed.Load()) {
+			return
+		}
+
+		capabilities[i], versions[i] = pm.describeVerified(ctx, plugins[i], reg, &report.Plugins[i])
+		if capabilities[i] == nil {
+			failed.Store(true)
+		}
+	})
 
-		output := bytes.NewBuffer(nil)
-		cmd := exec.CommandContext(ctx, cleanPath(plugin.Path), "capabilities") //nolint:gosec // G204 does not apply
-		cmd.Stdout = output
-		cmd.Stderr = os.Stderr
+	// Remember every file that was looked at, so Watch only acts on it once it changes.
+	defer func() {
+		for i, entry := range report.Plugins {
+			if entry.Status == StatusFiltered || entry.Status == StatusDiscovered && capabilities[i] == nil {
+				continue
+			}
+
+			file := &pluginFile{version: versions[i]}
+			if entry.Status == StatusRegistered {
+				file.id = entry.ID
+			}
+			pm.files[fileKey(entry.Path)] = file
+		}
+	}()
 
-		// Use Wait so we get the capabilities and make sure that the command exists and returns the values we need.
-		if err := cmd.Run(); err != nil {
-			slog.WarnContext(ctx, "failed to get capabilities from plugin, skipping", "plugin", plugin.ID, "error", err)
+	// Prepare the plugins in path order so the registry resolves conflicts deterministically.
+	var (
+		batch   []types.Plugin
+		entries []*PluginReport
+	)
+	for i, plugin := range plugins {
+		entry := &report.Plugins[i]
+		if entry.Err != nil && reg.opts.Strict {
+			return report, strictError(entry)
+		}
+
+		if entry.Status != StatusDiscovered || capabilities[i] == nil {
 			continue
 		}
 
-		if err := pm.addPlugin(pm.baseCtx, *plugin, output); err != nil {
-			slog.WarnContext(ctx, "failed to add plugin, skipping", "plugin", plugin.ID, "error", err)
+		prepared, lost, err := pm.preparePlugin(reg, *plugin, capabilities[i])
+		entry.LostTypes = lost
+		if err != nil {
+			failEntry(ctx, entry, StatusFailed, err)
+			if reg.opts.Strict {
+				return report, strictError(entry)
+			}
+
 			continue
 		}
+
+		batch = append(batch, prepared)
+		entries = append(entries, entry)
 	}
 
-	return nil
+	// Register the plugins with the registry which starts and supervises the plugin processes.
+	errs := pm.Registry.AddExternalPlugins(ctx, batch, reg.opts.StartupConcurrency, reg.pluginOptions()...)
+	for i, err := range errs {
+		if err != nil {
+			failEntry(ctx, entries[i], StatusFailed, err)
+			continue
+		}
+
+		entries[i].Status = StatusRegistered
+	}
+
+	if reg.opts.Strict {
+		for _, entry := range entries {
+			if entry.Err != nil {
+				return report, strictError(entry)
+			}
+		}
+	}
+
+	if len(report.Registered()) == 0 {
+		return report, fmt.Errorf("%w: %w", ErrNoPluginsRegistered, report.Err())
+	}
+
+	return report, nil
+}
+
+// failEntry records the failure of a plugin in its report entry.
+func failEntry(ctx context.Context, entry *PluginReport, status PluginStatus, err error) {
+	entry.Status, entry.Phase, entry.Err = status, phaseOf(err), err
+	slog.WarnContext(ctx, "failed to register plugin, skipping", "plugin", entry.ID, "phase", entry.Phase, "error", err)
+}
+
+func strictError(entry *PluginReport) error {
+	return fmt.Errorf("%w: plugin %s failed in phase %s: %w", ErrRegistrationFailed, entry.ID, entry.Phase, entry.Err)
+}
+
+// describeVerified verifies and describes a single plugin. Failures are recorded in entry. The
+// returned version of the binary is read before it's verified and described, so a binary that
+// changes meanwhile is seen as changed by Watch. It's zero if the binary couldn't be read.
+// Verified plugins are described and run from a private copy of the verified binary.
+func (pm *PluginManager) describeVerified(ctx context.Context, plugin *types.Plugin, reg *registration, entry *PluginReport) (*types.PluginCapabilities, fileVersion) {
+	if err := ctx.Err(); err != nil {
+		failEntry(ctx, entry, StatusFailed, inPhase(PhaseCapabilities, err))
+		return nil, fileVersion{}
+	}
+
+	source := cleanPath(plugin.Path)
+	version, err := readFileVersion(source, fileVersion{})
+	if err != nil {
+		failEntry(ctx, entry, StatusFailed, inPhase(PhaseCapabilities, fmt.Errorf("failed to read plugin binary: %w", err)))
+		return nil, fileVersion{}
+	}
+
+	var check func(path string, content []byte) error
+	if reg.verify != nil {
+		content, checksum, err := reg.verify.verify(reg.dir, source)
+		if err != nil {
+			failEntry(ctx, entry, StatusRefused, inPhase(PhaseVerification, err))
+			return nil, version
+		}
+
+		path, err := verifiedCopy(reg.runtimeDir, filepath.Base(source), content, checksum)
+		if err != nil {
+			failEntry(ctx, entry, StatusFailed, inPhase(PhaseVerification, err))
+			return nil, version
+		}
+		plugin.Path, plugin.Checksum = path, checksum
+
+		// The manifest can change the plugin's ID, environment and limits, so it's verified like the binary.
+		check = func(path string, content []byte) error {
+			_, err := reg.verify.verifyContent(reg.dir, path, content)
+			return err
+		}
+	}
+
+	capabilities, err := pm.describePlugin(ctx, plugin, source, check)
+	if err != nil {
+		status := StatusFailed
+		if errors.Is(err, ErrVerificationFailed) {
+			status = StatusRefused
+		}
+		failEntry(ctx, entry, status, err)
+		return nil, version
+	}
+
+	entry.ID = plugin.ID
+	entry.Types = slices.Sorted(maps.Keys(capabilities.Types))
+
+	return capabilities, version
+}
+
+// describePlugin returns the capabilities of the plugin. They are read from the manifest next to
+// the binary at source, verified with check if that isn't nil, if the plugin has one. Otherwise,
+// the plugin binary is executed with the capabilities argument.
+func (pm *PluginManager) describePlugin(ctx context.Context, plugin *types.Plugin, source string, check func(path string, content []byte) error) (*types.PluginCapabilities, error) {
+	capabilities, err := applyManifest(plugin, source, check)
+	if err != nil {
+		return nil, err
+	}
+
+	if capabilities != nil {
+		slog.DebugContext(ctx, "read plugin capabilities from manifest", "plugin", plugin.ID, "manifest", plugin.Manifest)
+		return capabilities, nil
+	}
+
+	output := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, cleanPath(plugin.Path), "capabilities") //nolint:gosec // G204 does not apply
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+
+	// Use Wait so we get the capabilities and make sure that the command exists and returns the values we need.
+	if err := cmd.Run(); err != nil {
+		return nil, inPhase(PhaseCapabilities, err)
+	}
+
+	// Determine Configuration requirements.
+	capabilities = &types.PluginCapabilities{}
+	if err := json.Unmarshal(output.Bytes(), capabilities); err != nil {
+		return nil, inPhase(PhaseParse, fmt.Errorf("failed to unmarshal capabilities: %w", err))
+	}
+
+	return capabilities, nil
 }
 
 func cleanPath(path string) string {
 	return strings.Trim(path, `,;:'"|&*!@#$`)
 }
 
-// Shutdown is called to terminate all plugins.
-func (pm *PluginManager) Shutdown(ctx context.Context) error {
+// Shutdown is called to terminate all plugins. It waits until ctx is done for them to stop
+// and reports how each plugin process was stopped.
+func (pm *PluginManager) Shutdown(ctx context.Context) ([]registry.ShutdownResult, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	results, err := pm.Registry.Shutdown(ctx)
+	pm.removeRuntimeDirs()
+
+	return results, err
+}
+
+// UnregisterPlugin drains and stops the external plugin with the given ID and removes it from every
+// type it provides. Watch doesn't register it again unless its binary changes.
+func (pm *PluginManager) UnregisterPlugin(ctx context.Context, id string) error {
+	if err := pm.Registry.UnregisterPlugin(ctx, id); err != nil {
+		return err
+	}
+
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	return pm.Registry.Shutdown(ctx)
+	for _, file := range pm.files {
+		if file.id == id {
+			file.id = ""
+		}
+	}
+
+	return nil
+}
+
+// RestartPlugin drains the external plugin with the given ID and starts a new process for it.
+func (pm *PluginManager) RestartPlugin(ctx context.Context, id string) error {
+	return pm.Registry.RestartPlugin(ctx, id)
+}
+
+// ReloadConfig restarts the external plugin with the given ID with new configuration data.
+func (pm *PluginManager) ReloadConfig(ctx context.Context, id string, configData []types.ConfigData) error {
+	return pm.Registry.ReloadConfig(ctx, id, configData)
+}
+
+// PluginOutput returns the most recent lines the external plugin with the given ID wrote to stdout and stderr.
+func (pm *PluginManager) PluginOutput(id string) ([]registry.OutputLine, error) {
+	return pm.Registry.PluginOutput(id)
 }
 
-// GetPlugin returns a plugin that implements the specified contract.
-func (pm *PluginManager) GetPlugin(ctx context.Context, pluginType string) (contracts.PluginBase, error) {
-	return pm.Registry.GetPlugin(ctx, pluginType)
+// AddEventHandler registers a handler for the lifecycle events of all external plugins.
+func (pm *PluginManager) AddEventHandler(handler registry.EventHandler) {
+	pm.Registry.AddEventHandler(handler)
+}
+
+// GetPlugin returns a plugin that implements the specified contract. Pass registry.WithSelector
+// to choose between several plugins providing the same type.
+func (pm *PluginManager) GetPlugin(ctx context.Context, pluginType string, opts ...registry.GetOptionFn) (contracts.PluginBase, error) {
+	return pm.Registry.GetPlugin(ctx, pluginType, opts...)
+}
+
+// GetPluginFor returns the plugin that declared the sub-type for the specified type.
+func (pm *PluginManager) GetPluginFor(ctx context.Context, pluginType, subType string) (contracts.PluginBase, error) {
+	return pm.Registry.GetPluginFor(ctx, pluginType, subType)
+}
+
+// ListTypes returns every type, sub-type and plugin ID combination that is registered.
+func (pm *PluginManager) ListTypes() []registry.TypeEntry {
+	return pm.Registry.ListTypes()
+}
+
+// GetPlugins returns every plugin that provides the specified type.
+func (pm *PluginManager) GetPlugins(ctx context.Context, pluginType string) []contracts.PluginBase {
+	return pm.Registry.GetPlugins(ctx, pluginType)
+}
+
+// GetTyped returns a plugin for the specified type as the contract T, for example contracts.DataProcessor.
+// It works for internal and external plugins alike.
+func GetTyped[T contracts.PluginBase](ctx context.Context, pm *PluginManager, pluginType string, opts ...registry.GetOptionFn) (T, error) {
+	return registry.GetTyped[T](ctx, pm.Registry, pluginType, opts...)
 }
 
 // ExternalPluginWrapper is re-exported from registry for use by client applications.
@@ -163,7 +690,7 @@ func (pm *PluginManager) RegisterInternalPlugin(pluginType string, plugin contra
 	return pm.Registry.RegisterInternal(pluginType, plugin)
 }
 
-func (pm *PluginManager) fetchPlugins(ctx context.Context, conf *types.Config, dir string, filter func(string) bool) ([]*types.Plugin, error) {
+func (pm *PluginManager) fetchPlugins(ctx context.Context, conf *types.Config, dir string) ([]*types.Plugin, error) {
 	var plugins []*types.Plugin
 	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if os.IsNotExist(err) {
@@ -186,12 +713,6 @@ func (pm *PluginManager) fetchPlugins(ctx context.Context, conf *types.Config, d
 
 		id := filepath.Base(path)
 
-		// Apply filter if provided
-		if !filter(id) {
-			slog.DebugContext(ctx, "skipping plugin due to filter", "id", id, "path", path)
-			return nil
-		}
-
 		p := &types.Plugin{
 			ID:     id,
 			Path:   path,
@@ -207,47 +728,68 @@ func (pm *PluginManager) fetchPlugins(ctx context.Context, conf *types.Config, d
 		return nil, fmt.Errorf("failed to discover plugins: %w", err)
 	}
 
+	slices.SortFunc(plugins, func(a, b *types.Plugin) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
 	return plugins, nil
 }
 
-func (pm *PluginManager) addPlugin(ctx context.Context, plugin types.Plugin, capabilitiesCommandOutput *bytes.Buffer) error {
-	// Determine Configuration requirements.
-	capabilities := &types.PluginCapabilities{}
-	if err := json.Unmarshal(capabilitiesCommandOutput.Bytes(), capabilities); err != nil {
-		return fmt.Errorf("failed to unmarshal capabilities: %w", err)
-	}
+// preparePlugin sets up the plugin for all types it declares that aren't provided by an
+// internal plugin. It returns the types that were dropped because of such conflicts.
+func (pm *PluginManager) preparePlugin(reg *registration, plugin types.Plugin, capabilities *types.PluginCapabilities) (types.Plugin, []string, error) {
+	plugin.Config = reg.conf
+	plugin.Config.ID = plugin.ID
 
-	serialized, err := json.Marshal(plugin.Config)
+	// The hash covers all declared types because that's what the running plugin reports.
+	hash, err := types.HashCapabilities(*capabilities)
 	if err != nil {
-		return err
+		return plugin, nil, inPhase(PhaseParse, err)
 	}
 
-	// Create a command that can then be managed.
-	pluginCmd := exec.CommandContext(ctx, cleanPath(plugin.Path), "--config", string(serialized)) //nolint:gosec // G204 does not apply
-	pluginCmd.Cancel = func() error {
-		slog.Info("killing plugin process because the parent context is cancelled", "id", plugin.ID)
-		return pluginCmd.Process.Kill()
+	internal := make(map[string]bool)
+	for _, entry := range pm.Registry.ListTypes() {
+		if entry.Internal {
+			internal[entry.Type] = true
+		}
 	}
 
-	// Set up communication pipes.
-	plugin.Cmd = pluginCmd
-	sdtErr, err := pluginCmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	var lost []string
+	pluginTypes := make(map[string][]types.TypeInfo, len(capabilities.Types))
+	for pluginType, infos := range capabilities.Types {
+		if internal[pluginType] {
+			lost = append(lost, pluginType)
+			continue
+		}
+		pluginTypes[pluginType] = infos
 	}
+	slices.Sort(lost)
 
-	sdtOut, err := pluginCmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	if len(pluginTypes) == 0 && len(lost) > 0 {
+		return plugin, lost, fmt.Errorf("all types of the plugin are provided by internal plugins: %w", registry.ErrAlreadyRegistered)
 	}
 
-	plugin.Types = capabilities.Types
+	plugin.Path = cleanPath(plugin.Path)
+	plugin.Types = pluginTypes
+	plugin.CapabilitiesHash = hash
+	plugin.Resources = plugin.Resources.Merge(reg.opts.Resources)
 
-	// Register the plugin with the registry
-	return pm.Registry.AddExternalPlugin(plugin)
+	return plugin, lost, nil
 }
 
+// determineConnectionType returns the connection type plugins use by default: abstract sockets
+// if they are supported, else Unix sockets if they can be created and TCP otherwise.
 func determineConnectionType() (types.ConnectionType, error) {
+	if plugins.AbstractSocketsSupported {
+		if listener, err := net.Listen("unix", "@go-plugin-framework-probe-"+rand.Text()); err == nil {
+			if err := listener.Close(); err != nil {
+				return "", fmt.Errorf("failed to close socket: %w", err)
+			}
+
+			return types.AbstractSocket, nil
+		}
+	}
+
 	tmp, err := os.MkdirTemp("", "")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temporary directory: %w", err)
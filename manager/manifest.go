@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// loadManifest loads the manifest next to the plugin binary. It returns nil and an empty path
+// if the plugin has no manifest. If check isn't nil, it verifies the content of the manifest
+// before it's parsed.
+func loadManifest(binaryPath string, check func(path string, content []byte) error) (*types.Manifest, string, error) {
+	for _, suffix := range types.ManifestSuffixes {
+		path := binaryPath + suffix
+
+		content, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, path, inPhase(PhaseCapabilities, fmt.Errorf("failed to read manifest: %w", err))
+		}
+
+		if check != nil {
+			if err := check(path, content); err != nil {
+				return nil, path, inPhase(PhaseVerification, err)
+			}
+		}
+
+		manifest, err := parseManifest(content, filepath.Ext(path))
+		if err != nil {
+			return nil, path, inPhase(PhaseParse, fmt.Errorf("failed to parse manifest %s: %w", path, err))
+		}
+
+		return manifest, path, nil
+	}
+
+	return nil, "", nil
+}
+
+// parseManifest parses a JSON or YAML manifest. YAML manifests are converted to JSON first so
+// both formats share the JSON field names and inline JSON schemas.
+func parseManifest(content []byte, ext string) (*types.Manifest, error) {
+	if ext != ".json" {
+		var doc any
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, err
+		}
+
+		converted, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert YAML manifest: %w", err)
+		}
+		content = converted
+	}
+
+	manifest := &types.Manifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// missingEnv returns the environment variables required by the manifest that aren't set.
+func missingEnv(manifest *types.Manifest) []string {
+	var missing []string
+	for _, name := range manifest.Env {
+		if _, ok := os.LookupEnv(name); !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
+
+// applyManifest reads the manifest next to the plugin binary at binaryPath if it has one and
+// returns the capabilities it declares. It returns nil capabilities if the plugin has no
+// manifest. The manifest is verified with check if that isn't nil.
+func applyManifest(plugin *types.Plugin, binaryPath string, check func(path string, content []byte) error) (*types.PluginCapabilities, error) {
+	manifest, path, err := loadManifest(binaryPath, check)
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+
+	if missing := missingEnv(manifest); len(missing) > 0 {
+		return nil, inPhase(PhaseCapabilities, fmt.Errorf("plugin requires unset environment variables: %s", strings.Join(missing, ", ")))
+	}
+
+	capabilities, err := manifest.Capabilities()
+	if err != nil {
+		return nil, inPhase(PhaseParse, fmt.Errorf("invalid manifest %s: %w", path, err))
+	}
+
+	if manifest.ID != "" {
+		plugin.ID = manifest.ID
+	}
+	plugin.Version = manifest.Version
+	plugin.Manifest = path
+	plugin.Resources = manifest.Resources
+
+	return capabilities, nil
+}
@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "my-plugin")
+
+	manifest, path, err := loadManifest(binary, nil)
+	require.NoError(t, err)
+	require.Nil(t, manifest)
+	require.Empty(t, path)
+
+	require.NoError(t, os.WriteFile(binary+".plugin.yaml", []byte(`
+id: text-processor
+version: 1.2.0
+types:
+  dataProcessor:
+    - type: uppercase
+      jsonSchema:
+        type: object
+        properties:
+          text:
+            type: string
+configTypes: [logging-config]
+env: [HOME]
+resources:
+  memoryBytes: 104857600
+  openFiles: 64
+`), 0o600))
+
+	manifest, path, err = loadManifest(binary, nil)
+	require.NoError(t, err)
+	require.Equal(t, binary+".plugin.yaml", path)
+	require.Equal(t, "text-processor", manifest.ID)
+	require.Equal(t, "1.2.0", manifest.Version)
+	require.Equal(t, []string{"logging-config"}, manifest.ConfigTypes)
+	require.Equal(t, &types.ResourceLimits{MemoryBytes: 104857600, OpenFiles: 64}, manifest.Resources)
+
+	capabilities, err := manifest.Capabilities()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type": "object", "properties": {"text": {"type": "string"}}}`, string(capabilities.Types["dataProcessor"][0].JSONSchema))
+
+	// The manifest has to hash the same as the capabilities reported by the binary.
+	manifestHash, err := types.HashCapabilities(*capabilities)
+	require.NoError(t, err)
+	binaryHash, err := types.HashCapabilities(types.PluginCapabilities{
+		Types: map[string][]types.TypeInfo{
+			"dataProcessor": {{
+				Type:       "uppercase",
+				JSONSchema: []byte(`{"properties": {"text": {"type": "string"}}, "type": "object"}`),
+			}},
+		},
+		ConfigTypes: []string{"logging-config"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, binaryHash, manifestHash)
+
+	// JSON manifests take precedence over YAML ones.
+	require.NoError(t, os.WriteFile(binary+".plugin.json", []byte(`{"types": {"transformer": [{"type": "reverse"}]}}`), 0o600))
+
+	manifest, path, err = loadManifest(binary, nil)
+	require.NoError(t, err)
+	require.Equal(t, binary+".plugin.json", path)
+	require.Contains(t, manifest.Types, "transformer")
+
+	require.NoError(t, os.WriteFile(binary+".plugin.json", []byte(`{"types": `), 0o600))
+	_, _, err = loadManifest(binary, nil)
+	require.ErrorContains(t, err, "failed to parse manifest")
+}
+
+func TestApplyManifest(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "my-plugin")
+	plugin := &types.Plugin{ID: "my-plugin", Path: binary}
+
+	capabilities, err := applyManifest(plugin, binary, nil)
+	require.NoError(t, err)
+	require.Nil(t, capabilities)
+
+	require.NoError(t, os.WriteFile(binary+".plugin.json", []byte(`{"types": {}}`), 0o600))
+	_, err = applyManifest(plugin, binary, nil)
+	require.ErrorContains(t, err, "manifest declares no types")
+
+	require.NoError(t, os.WriteFile(binary+".plugin.json", []byte(`{"types": {"transformer": [{"type": "reverse"}]}, "env": ["PLUGIN_MANIFEST_TEST_UNSET"]}`), 0o600))
+	_, err = applyManifest(plugin, binary, nil)
+	require.ErrorContains(t, err, "PLUGIN_MANIFEST_TEST_UNSET")
+
+	t.Setenv("PLUGIN_MANIFEST_TEST_UNSET", "set")
+	capabilities, err = applyManifest(plugin, binary, nil)
+	require.NoError(t, err)
+	require.Equal(t, []types.TypeInfo{{Type: "reverse"}}, capabilities.Types["transformer"])
+	require.Equal(t, "my-plugin", plugin.ID)
+	require.Equal(t, binary+".plugin.json", plugin.Manifest)
+}
+
+func TestManifestResourceLimits(t *testing.T) {
+	pm := NewPluginManager(t.Context())
+	reg, err := newRegistration([]RegistrationOptionFn{
+		WithResourceLimits(types.ResourceLimits{MemoryBytes: 1 << 30, CPUs: 2}),
+	})
+	require.NoError(t, err)
+
+	capabilities := &types.PluginCapabilities{Types: map[string][]types.TypeInfo{"transformer": {{Type: "reverse"}}}}
+
+	// The host's limits apply to plugins without a manifest.
+	prepared, _, err := pm.preparePlugin(reg, types.Plugin{ID: "my-plugin"}, capabilities)
+	require.NoError(t, err)
+	require.Equal(t, &types.ResourceLimits{MemoryBytes: 1 << 30, CPUs: 2}, prepared.Resources)
+
+	// A manifest can make the limits stricter and add new ones, but not loosen them.
+	prepared, _, err = pm.preparePlugin(reg, types.Plugin{
+		ID:        "my-plugin",
+		Resources: &types.ResourceLimits{MemoryBytes: 1 << 31, CPUs: 0.5, OpenFiles: 64},
+	}, capabilities)
+	require.NoError(t, err)
+	require.Equal(t, &types.ResourceLimits{MemoryBytes: 1 << 30, CPUs: 0.5, OpenFiles: 64}, prepared.Resources)
+}
@@ -0,0 +1,154 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Skarlso/go-plugin-framework/registry"
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+)
+
+var (
+	// ErrNoPluginsRegistered is returned together with the report if none of the discovered plugins could be registered.
+	ErrNoPluginsRegistered = errors.New("no plugins could be registered")
+	// ErrRegistrationFailed is returned together with the report if a plugin failed in strict mode.
+	ErrRegistrationFailed = errors.New("plugin registration failed")
+)
+
+// PluginStatus is the outcome of registering a single plugin.
+type PluginStatus string
+
+const (
+	// StatusDiscovered marks a plugin that was found but not processed because registration stopped early.
+	StatusDiscovered PluginStatus = "discovered"
+	// StatusFiltered marks a plugin that was skipped by the plugin filter.
+	StatusFiltered PluginStatus = "filtered"
+	// StatusRegistered marks a plugin that was added to the registry.
+	StatusRegistered PluginStatus = "registered"
+	// StatusRefused marks a plugin whose binary failed verification and was never executed.
+	StatusRefused PluginStatus = "refused"
+	// StatusFailed marks a plugin that couldn't be described or started.
+	StatusFailed PluginStatus = "failed"
+)
+
+// Phase is the registration step a plugin failed in.
+type Phase string
+
+const (
+	// PhaseVerification is the verification of the plugin binary.
+	PhaseVerification Phase = "verification"
+	// PhaseCapabilities is reading the manifest or running the capabilities command.
+	PhaseCapabilities Phase = "capabilities"
+	// PhaseParse is parsing the capabilities and compiling the declared JSON schemas.
+	PhaseParse Phase = "parse"
+	// PhaseRegister is adding the plugin to the registry, which fails if its ID or all its types are taken.
+	PhaseRegister Phase = "register"
+	// PhaseStart is starting the plugin process.
+	PhaseStart Phase = "start"
+	// PhaseHandshake is reading and checking the handshake of the plugin.
+	PhaseHandshake Phase = "handshake"
+	// PhaseHealth is waiting for the plugin to pass its health check.
+	PhaseHealth Phase = "health"
+)
+
+// phaseError is an error that happened in a specific registration phase.
+type phaseError struct {
+	phase Phase
+	err   error
+}
+
+func (e *phaseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *phaseError) Unwrap() error {
+	return e.err
+}
+
+// inPhase attributes err to phase.
+func inPhase(phase Phase, err error) error {
+	return &phaseError{phase: phase, err: err}
+}
+
+// phaseOf returns the phase err happened in. Errors returned by the registry are
+// attributed based on their cause.
+func phaseOf(err error) Phase {
+	var pe *phaseError
+	switch {
+	case errors.As(err, &pe):
+		return pe.phase
+	case errors.Is(err, registry.ErrAlreadyRegistered):
+		return PhaseRegister
+	case errors.Is(err, registry.ErrInvalidSchema):
+		return PhaseParse
+	case errors.Is(err, plugins.ErrHandshake):
+		return PhaseHandshake
+	case errors.Is(err, plugins.ErrNotReady):
+		return PhaseHealth
+	default:
+		return PhaseStart
+	}
+}
+
+// PluginReport describes what happened to a single discovered plugin file during registration.
+type PluginReport struct {
+	// ID is the plugin ID.
+	ID string
+	// Path is the path of the plugin binary.
+	Path string
+	// Status is the outcome of the registration.
+	Status PluginStatus
+	// Phase is the phase the plugin failed in. It is only set for refused and failed plugins.
+	Phase Phase
+	// Err is the reason the plugin was refused or failed.
+	Err error
+	// Types lists the types the plugin claimed, sorted.
+	Types []string
+	// LostTypes lists the claimed types the plugin wasn't registered for because an internal
+	// plugin already provides them, sorted.
+	LostTypes []string
+}
+
+// RegistrationReport is returned by RegisterPlugins and lists the outcome for every discovered plugin file.
+type RegistrationReport struct {
+	// Plugins holds a report per discovered plugin file in the order they were discovered.
+	Plugins []PluginReport
+}
+
+// Registered returns the reports of the plugins that were registered.
+func (r *RegistrationReport) Registered() []PluginReport {
+	return r.withStatus(StatusRegistered)
+}
+
+// Refused returns the reports of the plugins that failed verification.
+func (r *RegistrationReport) Refused() []PluginReport {
+	return r.withStatus(StatusRefused)
+}
+
+// Failed returns the reports of the plugins that couldn't be described or started.
+func (r *RegistrationReport) Failed() []PluginReport {
+	return r.withStatus(StatusFailed)
+}
+
+// Err returns the errors of all refused and failed plugins joined together, or nil if there are none.
+func (r *RegistrationReport) Err() error {
+	var errs []error
+	for _, report := range r.Plugins {
+		if report.Err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s failed in phase %s: %w", report.ID, report.Phase, report.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *RegistrationReport) withStatus(status PluginStatus) []PluginReport {
+	var result []PluginReport
+	for _, report := range r.Plugins {
+		if report.Status == status {
+			result = append(result, report)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeScriptPlugin writes a shell script plugin that prints capabilities for the capabilities
+// command and runs serve otherwise.
+func writeScriptPlugin(t *testing.T, dir, name, capabilities, serve string) {
+	t.Helper()
+
+	script := "#!/bin/sh\nif [ \"$1\" = capabilities ]; then\n" + capabilities + "\nfi\n" + serve + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(script), 0o700))
+}
+
+type internalPlugin struct{}
+
+func (internalPlugin) Ping(context.Context) error { return nil }
+
+func TestRegistrationReport(t *testing.T) {
+	dir := t.TempDir()
+	writeScriptPlugin(t, dir, "a-broken", "exit 1", "exit 1")
+	writeScriptPlugin(t, dir, "b-garbage", "echo 'not json'; exit 0", "exit 1")
+	writeScriptPlugin(t, dir, "c-silent", `echo '{"types": {"silentType": [{"type": "y"}]}}'; exit 0`, "echo starting; exit 0")
+	writeScriptPlugin(t, dir, "d-internal", `echo '{"types": {"internalType": [{"type": "x"}]}}'; exit 0`, "exit 0")
+	writeScriptPlugin(t, dir, "e-filtered", "exit 1", "exit 1")
+
+	pm := NewPluginManager(t.Context())
+	require.NoError(t, pm.RegisterInternalPlugin("internalType", internalPlugin{}))
+
+	report, err := pm.RegisterPlugins(t.Context(), dir, WithPluginFilter(func(id string) bool {
+		return id != "e-filtered"
+	}))
+	require.ErrorIs(t, err, ErrNoPluginsRegistered)
+	require.Len(t, report.Plugins, 5)
+	require.Len(t, report.Failed(), 4)
+
+	phases := map[string]Phase{}
+	for _, plugin := range report.Failed() {
+		phases[plugin.ID] = plugin.Phase
+	}
+	require.Equal(t, map[string]Phase{
+		"a-broken":   PhaseCapabilities,
+		"b-garbage":  PhaseParse,
+		"c-silent":   PhaseHandshake,
+		"d-internal": PhaseRegister,
+	}, phases)
+
+	require.Equal(t, []string{"internalType"}, report.Plugins[3].Types)
+	require.Equal(t, []string{"internalType"}, report.Plugins[3].LostTypes)
+	require.Equal(t, StatusFiltered, report.Plugins[4].Status)
+	require.ErrorContains(t, report.Err(), "plugin b-garbage failed in phase parse")
+}
+
+func TestRegisterPluginsStrict(t *testing.T) {
+	dir := t.TempDir()
+	writeScriptPlugin(t, dir, "a-broken", "exit 1", "exit 1")
+	writeScriptPlugin(t, dir, "b-broken", "exit 1", "exit 1")
+
+	pm := NewPluginManager(t.Context())
+	report, err := pm.RegisterPlugins(t.Context(), dir, WithStrict())
+	require.ErrorIs(t, err, ErrRegistrationFailed)
+	require.ErrorContains(t, err, "plugin a-broken failed in phase capabilities")
+	require.Equal(t, StatusFailed, report.Plugins[0].Status)
+	require.Equal(t, StatusDiscovered, report.Plugins[1].Status)
+}
+
+func TestRegisterPluginsAllFiltered(t *testing.T) {
+	dir := t.TempDir()
+	writeScriptPlugin(t, dir, "my-plugin", "exit 1", "exit 1")
+
+	pm := NewPluginManager(t.Context())
+	report, err := pm.RegisterPlugins(t.Context(), dir, WithPluginFilter(func(string) bool { return false }))
+	require.ErrorIs(t, err, ErrNoPluginsFound)
+	require.Equal(t, StatusFiltered, report.Plugins[0].Status)
+}
+
+func TestRegisterPluginsDeadline(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a-slow", "b-slow", "c-slow"} {
+		writeScriptPlugin(t, dir, name, "exec sleep 10", "exit 1")
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+
+	pm := NewPluginManager(t.Context())
+	start := time.Now()
+	report, err := pm.RegisterPlugins(ctx, dir, WithStartupConcurrency(3))
+	require.ErrorIs(t, err, ErrNoPluginsRegistered)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	for _, plugin := range report.Plugins {
+		require.Equal(t, StatusFailed, plugin.Status)
+		require.Equal(t, PhaseCapabilities, plugin.Phase)
+	}
+}
@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Skarlso/go-plugin-framework/registry"
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+)
+
+// runtimeDirPrefix starts the name of every runtime directory. It's followed by the process ID
+// of the host that created the directory. It's short, as the socket paths of the plugins in the
+// directory are limited to about 100 bytes.
+const runtimeDirPrefix = "gpf-"
+
+// WithRuntimeDir creates the private runtime directory the plugins place their sockets and lock
+// files in below root instead of $XDG_RUNTIME_DIR or, if that isn't set, the temporary directory.
+func WithRuntimeDir(root string) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.RuntimeDir = root
+	}
+}
+
+// runtimeDirKey identifies a runtime directory of the manager by its root and the user that
+// owns it.
+type runtimeDirKey struct {
+	root  string
+	owner registry.Credential
+}
+
+// runtimeDir returns the private runtime directory of the manager below root, creating it
+// first if needed. Runtime directories of hosts that are no longer running are removed before.
+// Plugins running as a different user get their own directory owned by that user, below a root
+// that user can traverse. Without a root, that's $XDG_RUNTIME_DIR if the user can traverse it,
+// which it usually can't as it belongs to the host's user, and the temporary directory otherwise.
+func (pm *PluginManager) runtimeDir(root string, owner *registry.Credential) (string, error) {
+	if root == "" {
+		root = os.Getenv("XDG_RUNTIME_DIR")
+		if root == "" || owner != nil && !traversable(root, *owner) {
+			root = os.TempDir()
+		}
+	}
+
+	if owner != nil && !traversable(root, *owner) {
+		return "", fmt.Errorf("plugin runtime directory root %s can't be traversed by user %d, choose another root with WithRuntimeDir", root, owner.UID)
+	}
+
+	key := runtimeDirKey{root: root}
+	if owner != nil {
+		key.owner = *owner
+	}
+
+	pm.sharedMu.Lock()
+	defer pm.sharedMu.Unlock()
+
+	if dir, ok := pm.runtimeDirs[key]; ok {
+		return dir, nil
+	}
+
+	removeStaleRuntimeDirs(root)
+
+	// The directory has a random name and is only accessible by the host's user.
+	dir, err := os.MkdirTemp(root, runtimeDirPrefix+strconv.Itoa(os.Getpid())+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the plugin runtime directory: %w", err)
+	}
+
+	if err := plugins.CheckSocketDir(dir); err != nil {
+		_ = os.Remove(dir)
+
+		return "", fmt.Errorf("plugin runtime directory below %s can't hold plugin sockets, choose another root with WithRuntimeDir: %w", root, err)
+	}
+
+	if owner != nil {
+		if err := os.Chown(dir, int(owner.UID), int(owner.GID)); err != nil {
+			_ = os.RemoveAll(dir)
+
+			return "", fmt.Errorf("failed to hand the plugin runtime directory to user %d: %w", owner.UID, err)
+		}
+	}
+	pm.runtimeDirs[key] = dir
+
+	return dir, nil
+}
+
+// removeRuntimeDirs removes the runtime directories of the manager.
+func (pm *PluginManager) removeRuntimeDirs() {
+	pm.sharedMu.Lock()
+	defer pm.sharedMu.Unlock()
+
+	for key, dir := range pm.runtimeDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("failed to remove the plugin runtime directory", "dir", dir, "error", err)
+		}
+		delete(pm.runtimeDirs, key)
+	}
+}
+
+// removeStaleRuntimeDirs removes the runtime directories below root that were left behind by
+// hosts that are no longer running.
+func removeStaleRuntimeDirs(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		rest, ok := strings.CutPrefix(entry.Name(), runtimeDirPrefix)
+		if !ok || !entry.IsDir() {
+			continue
+		}
+
+		pidText, _, _ := strings.Cut(rest, "-")
+		pid, err := strconv.Atoi(pidText)
+		if err != nil || processExists(pid) {
+			continue
+		}
+
+		dir := filepath.Join(root, entry.Name())
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Debug("failed to remove a stale plugin runtime directory", "dir", dir, "error", err)
+			continue
+		}
+		slog.Info("removed the plugin runtime directory of a host that is no longer running", "dir", dir, "pid", pid)
+	}
+}
+
+// processExists reports whether a process with the given ID is running. Processes that can't
+// be signalled, for example because they belong to another user, are considered running.
+func processExists(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = process.Signal(syscall.Signal(0))
+
+	return !errors.Is(err, os.ErrProcessDone)
+}
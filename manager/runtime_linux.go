@@ -0,0 +1,56 @@
+//go:build linux
+
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Skarlso/go-plugin-framework/registry"
+)
+
+// traversable reports whether the user can reach the entries of dir, which needs the search
+// permission on dir and every directory above it. Only the primary group of the user is
+// considered.
+func traversable(dir string, user registry.Credential) bool {
+	if user.UID == 0 {
+		return true
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+
+	for {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return false
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return false
+		}
+
+		perm := info.Mode().Perm()
+		switch {
+		case stat.Uid == user.UID:
+			perm &= 0o100
+		case stat.Gid == user.GID:
+			perm &= 0o010
+		default:
+			perm &= 0o001
+		}
+		if perm == 0 {
+			return false
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return true
+		}
+		dir = parent
+	}
+}
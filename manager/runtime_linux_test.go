@@ -0,0 +1,55 @@
+//go:build linux
+
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/registry"
+)
+
+func TestRuntimeDirCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("handing the runtime directory to another user needs root")
+	}
+
+	owner := &registry.Credential{UID: 65534, GID: 65534}
+
+	// The runtime directory of the host's user can't be traversed by the plugin user.
+	private := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", private)
+	require.False(t, traversable(private, *owner))
+
+	shared, err := os.MkdirTemp("", "runtime-root-")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(shared) })
+	require.NoError(t, os.Chmod(shared, 0o711))
+	t.Setenv("TMPDIR", shared)
+	require.True(t, traversable(shared, *owner))
+
+	pm := NewPluginManager(t.Context())
+	dir, err := pm.runtimeDir("", owner)
+	require.NoError(t, err)
+	require.Equal(t, shared, filepath.Dir(dir))
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, owner.UID, info.Sys().(*syscall.Stat_t).Uid)
+
+	// Roots given explicitly aren't replaced.
+	_, err = pm.runtimeDir(private, owner)
+	require.ErrorContains(t, err, "can't be traversed by user 65534")
+
+	// Plugins running as the host's user keep using its runtime directory.
+	dir, err = pm.runtimeDir("", nil)
+	require.NoError(t, err)
+	require.Equal(t, private, filepath.Dir(dir))
+
+	_, err = pm.Shutdown(t.Context())
+	require.NoError(t, err)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package manager
+
+import "github.com/Skarlso/go-plugin-framework/registry"
+
+// traversable reports whether the user can reach the entries of dir. Plugins only run as a
+// different user on Linux, so it's always true.
+func traversable(string, registry.Credential) bool {
+	return true
+}
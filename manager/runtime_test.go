@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+func TestRuntimeDir(t *testing.T) {
+	root := t.TempDir()
+
+	// A host that is no longer running left its directory behind, another one is still running.
+	stale := filepath.Join(root, runtimeDirPrefix+"999999999-1")
+	require.NoError(t, os.Mkdir(stale, 0o700))
+	running := filepath.Join(root, runtimeDirPrefix+strconv.Itoa(os.Getppid())+"-1")
+	require.NoError(t, os.Mkdir(running, 0o700))
+
+	pm := NewPluginManager(t.Context())
+	dir, err := pm.runtimeDir(root, nil)
+	require.NoError(t, err)
+	require.Equal(t, root, filepath.Dir(dir))
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.ModeDir|0o700, info.Mode())
+
+	require.NoDirExists(t, stale)
+	require.DirExists(t, running)
+
+	// Every registration shares the directory.
+	again, err := pm.runtimeDir(root, nil)
+	require.NoError(t, err)
+	require.Equal(t, dir, again)
+
+	_, err = pm.Shutdown(t.Context())
+	require.NoError(t, err)
+	require.NoDirExists(t, dir)
+
+	// Roots too long for the plugin socket paths are refused.
+	long := filepath.Join(root, strings.Repeat("r", plugins.MaxSocketPathLength-len(root)))
+	require.NoError(t, os.Mkdir(long, 0o700))
+	_, err = NewPluginManager(t.Context()).runtimeDir(long, nil)
+	require.ErrorIs(t, err, plugins.ErrSocketPathTooLong)
+	require.ErrorContains(t, err, "WithRuntimeDir")
+	entries, err := os.ReadDir(long)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestRegistrationRuntimeDir(t *testing.T) {
+	root := t.TempDir()
+
+	pm := NewPluginManager(t.Context())
+	reg, err := pm.newRegistration([]RegistrationOptionFn{WithRuntimeDir(root), WithConnectionType(types.Socket)})
+	require.NoError(t, err)
+	require.Equal(t, root, filepath.Dir(reg.conf.RuntimeDir))
+
+	// Plugins connected over TCP or abstract sockets don't need a runtime directory.
+	reg, err = pm.newRegistration([]RegistrationOptionFn{WithRuntimeDir(root), WithConnectionType(types.TCP)})
+	require.NoError(t, err)
+	require.Empty(t, reg.conf.RuntimeDir)
+}
+
+func TestRegistrationTLS(t *testing.T) {
+	// TLS implies TCP connections.
+	reg, err := newRegistration([]RegistrationOptionFn{WithTLS()})
+	require.NoError(t, err)
+	require.Equal(t, types.TCP, reg.conf.Type)
+
+	_, err = newRegistration([]RegistrationOptionFn{WithTLS(), WithConnectionType(types.Socket)})
+	require.ErrorContains(t, err, "TLS is only supported for TCP connections")
+}
@@ -0,0 +1,200 @@
+package manager
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureSuffix is the suffix of detached plugin signatures. The signature of the plugin binary
+// ./plugins/my-plugin is ./plugins/my-plugin.sig.
+const SignatureSuffix = ".sig"
+
+// ErrVerificationFailed is returned for plugin binaries that failed verification.
+var ErrVerificationFailed = errors.New("plugin verification failed")
+
+// Verification configures how plugin binaries are verified before they are executed.
+// If both a checksum file and public keys are set, a binary has to pass both checks.
+type Verification struct {
+	// ChecksumFile is a file in the format written by sha256sum that lists the SHA-256
+	// checksums of the allowed plugin binaries by their path relative to the plugin directory.
+	// It can be created with "sha256sum *" inside the plugin directory.
+	ChecksumFile string
+	// PublicKeys are the trusted ed25519 public keys. Every plugin binary needs a base64
+	// encoded detached signature of its content, made by one of the keys, in <binary>.sig.
+	PublicKeys []ed25519.PublicKey
+}
+
+// verifier checks plugin binaries against a Verification.
+type verifier struct {
+	// checksums holds the allowed hex encoded checksums by slash separated path relative to the plugin directory.
+	checksums map[string]string
+	keys      []ed25519.PublicKey
+}
+
+func newVerifier(verification *Verification) (*verifier, error) {
+	if verification.ChecksumFile == "" && len(verification.PublicKeys) == 0 {
+		return nil, errors.New("verification needs a checksum file or public keys")
+	}
+
+	for i, key := range verification.PublicKeys {
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key %d has an invalid size of %d bytes", i, len(key))
+		}
+	}
+
+	v := &verifier{keys: verification.PublicKeys}
+	if verification.ChecksumFile != "" {
+		checksums, err := readChecksumFile(verification.ChecksumFile)
+		if err != nil {
+			return nil, err
+		}
+		v.checksums = checksums
+	}
+
+	return v, nil
+}
+
+// readChecksumFile reads lines of the form "<hex checksum>  <path>". A '*' in front of the
+// path, which sha256sum writes in binary mode, is ignored.
+func readChecksumFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checksum file: %w", err)
+	}
+	defer file.Close()
+
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		sum, name, ok := strings.Cut(text, " ")
+		decoded, err := hex.DecodeString(sum)
+		if !ok || err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid checksum file %s at line %d", path, line)
+		}
+
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+		checksums[filepath.ToSlash(filepath.Clean(name))] = strings.ToLower(sum)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksum file: %w", err)
+	}
+
+	return checksums, nil
+}
+
+// verify checks the binary at path inside the plugin directory dir. It returns the verified
+// content, so the binary can be run from a copy of it instead of the file that may have changed
+// since, and its checksum in the form "sha256:<hex>".
+func (v *verifier) verify(dir, path string) ([]byte, string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read plugin binary: %w", err)
+	}
+
+	checksum, err := v.verifyContent(dir, path, content)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return content, checksum, nil
+}
+
+// verifyContent checks the content read from the file at path inside the plugin directory dir
+// and returns its checksum in the form "sha256:<hex>". Binaries and their manifests are checked
+// the same way.
+func (v *verifier) verifyContent(dir, path string, content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	if v.checksums != nil {
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s is not in the plugin directory: %w", ErrVerificationFailed, path, err)
+		}
+		name = filepath.ToSlash(name)
+
+		allowed, ok := v.checksums[name]
+		if !ok {
+			return "", fmt.Errorf("%w: %s is not listed in the checksum file", ErrVerificationFailed, name)
+		}
+
+		if allowed != checksum {
+			return "", fmt.Errorf("%w: checksum of %s is %s, expected %s", ErrVerificationFailed, name, checksum, allowed)
+		}
+	}
+
+	if len(v.keys) > 0 {
+		if err := v.verifySignature(path, content); err != nil {
+			return "", err
+		}
+	}
+
+	return "sha256:" + checksum, nil
+}
+
+// verifiedCopy writes the verified content of the plugin binary named name to the private
+// directory dir and returns the path of the copy. Plugins are run from the copy, so a binary that
+// is replaced after it was verified is never executed. Binaries with the same content share a copy.
+func verifiedCopy(dir, name string, content []byte, checksum string) (string, error) {
+	path := filepath.Join(dir, strings.TrimPrefix(checksum, "sha256:")[:16]+"-"+name)
+
+	file, err := os.CreateTemp(dir, ".copy-")
+	if err != nil {
+		return "", fmt.Errorf("failed to copy verified plugin binary: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	// Plugins running as another user have to be able to execute the copy, but not change it.
+	if err == nil {
+		err = os.Chmod(file.Name(), 0o555)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to copy verified plugin binary: %w", err)
+	}
+
+	return path, nil
+}
+
+func (v *verifier) verifySignature(path string, content []byte) error {
+	encoded, err := os.ReadFile(path + SignatureSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s has no signature", ErrVerificationFailed, filepath.Base(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read plugin signature: %w", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return fmt.Errorf("%w: invalid signature encoding for %s: %w", ErrVerificationFailed, filepath.Base(path), err)
+	}
+
+	for _, key := range v.keys {
+		if ed25519.Verify(key, content, signature) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: signature of %s is not made by a trusted key", ErrVerificationFailed, filepath.Base(path))
+}
@@ -0,0 +1,158 @@
+package manager
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+)
+
+func TestVerifierChecksums(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "my-plugin")
+	content := []byte("#!/bin/sh\necho plugin\n")
+	require.NoError(t, os.WriteFile(binary, content, 0o700))
+
+	sum := sha256.Sum256(content)
+	checksumFile := filepath.Join(dir, "SHA256SUMS")
+	require.NoError(t, os.WriteFile(checksumFile, []byte("# allowed plugins\n"+hex.EncodeToString(sum[:])+" *./my-plugin\n"+hex.EncodeToString(sum[:])+"  nested/my-plugin\n"), 0o600))
+
+	v, err := newVerifier(&Verification{ChecksumFile: checksumFile})
+	require.NoError(t, err)
+
+	_, checksum, err := v.verify(dir, binary)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), checksum)
+
+	// Binaries are listed by their path relative to the plugin directory.
+	nested := filepath.Join(dir, "nested", "my-plugin")
+	require.NoError(t, os.MkdirAll(filepath.Dir(nested), 0o700))
+	require.NoError(t, os.WriteFile(nested, content, 0o700))
+	_, _, err = v.verify(dir, nested)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(binary, []byte("tampered"), 0o700))
+	_, _, err = v.verify(dir, binary)
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.ErrorContains(t, err, "checksum of my-plugin")
+
+	// A binary with the same name in another directory isn't allowed.
+	other := filepath.Join(dir, "other", "my-plugin")
+	require.NoError(t, os.MkdirAll(filepath.Dir(other), 0o700))
+	require.NoError(t, os.WriteFile(other, content, 0o700))
+	_, _, err = v.verify(dir, other)
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.ErrorContains(t, err, "other/my-plugin is not listed")
+
+	require.NoError(t, os.WriteFile(checksumFile, []byte("not-a-checksum my-plugin\n"), 0o600))
+	_, err = newVerifier(&Verification{ChecksumFile: checksumFile})
+	require.ErrorContains(t, err, "at line 1")
+
+	_, err = newVerifier(&Verification{})
+	require.Error(t, err)
+}
+
+func TestVerifierSignatures(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "my-plugin")
+	content := []byte("#!/bin/sh\necho plugin\n")
+	require.NoError(t, os.WriteFile(binary, content, 0o700))
+
+	trusted, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	untrusted, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	v, err := newVerifier(&Verification{PublicKeys: []ed25519.PublicKey{untrusted, trusted}})
+	require.NoError(t, err)
+
+	_, _, err = v.verify(dir, binary)
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.ErrorContains(t, err, "has no signature")
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(private, content))
+	require.NoError(t, os.WriteFile(binary+SignatureSuffix, []byte(signature+"\n"), 0o600))
+
+	_, _, err = v.verify(dir, binary)
+	require.NoError(t, err)
+
+	v, err = newVerifier(&Verification{PublicKeys: []ed25519.PublicKey{untrusted}})
+	require.NoError(t, err)
+
+	_, _, err = v.verify(dir, binary)
+	require.ErrorIs(t, err, ErrVerificationFailed)
+	require.ErrorContains(t, err, "not made by a trusted key")
+
+	_, err = newVerifier(&Verification{PublicKeys: []ed25519.PublicKey{trusted[:8]}})
+	require.ErrorContains(t, err, "invalid size")
+}
+
+func TestRegisterPluginsRefusesUnverified(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "executed")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "my-plugin"), []byte("#!/bin/sh\ntouch "+marker+"\n"), 0o700))
+	checksumFile := filepath.Join(t.TempDir(), "SHA256SUMS")
+	require.NoError(t, os.WriteFile(checksumFile, nil, 0o600))
+
+	pm := NewPluginManager(t.Context())
+	report, err := pm.RegisterPlugins(t.Context(), dir, WithVerification(Verification{ChecksumFile: checksumFile}))
+	require.ErrorIs(t, err, ErrNoPluginsRegistered)
+	require.Len(t, report.Refused(), 1)
+	require.Equal(t, "my-plugin", report.Refused()[0].ID)
+	require.ErrorIs(t, report.Refused()[0].Err, ErrVerificationFailed)
+	require.Equal(t, PhaseVerification, report.Refused()[0].Phase)
+	require.Empty(t, report.Registered())
+	require.NoFileExists(t, marker)
+}
+
+func TestRegisterPluginsRunsVerifiedCopy(t *testing.T) {
+	dir := t.TempDir()
+	started := filepath.Join(t.TempDir(), "started")
+	writeScriptPlugin(t, dir, "my-plugin", capabilitiesScript("verified"), `echo "$0" > `+started+"; exit 1")
+	content, err := os.ReadFile(filepath.Join(dir, "my-plugin"))
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(content)
+	checksumFile := filepath.Join(t.TempDir(), "SHA256SUMS")
+	require.NoError(t, os.WriteFile(checksumFile, []byte(hex.EncodeToString(sum[:])+"  my-plugin\n"), 0o600))
+
+	root := t.TempDir()
+	pm := NewPluginManager(t.Context())
+	report, err := pm.RegisterPlugins(t.Context(), dir, WithOnDemand(), WithRuntimeDir(root),
+		WithVerification(Verification{ChecksumFile: checksumFile}))
+	require.NoError(t, err)
+	require.Len(t, report.Registered(), 1)
+
+	// A binary replaced after it was verified is never run, the verified copy is.
+	tampered := filepath.Join(t.TempDir(), "tampered")
+	writeScriptPlugin(t, dir, "my-plugin", capabilitiesScript("verified"), "touch "+tampered+"; exit 1")
+	_, err = pm.GetPlugin(t.Context(), "dataProcessor")
+	require.ErrorIs(t, err, plugins.ErrHandshake)
+	require.NoFileExists(t, tampered)
+
+	path, err := os.ReadFile(started)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(path), root+string(filepath.Separator)), string(path))
+
+	// Manifests can change the plugin's ID and environment, so they are verified as well.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "my-plugin"), content, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "my-plugin.plugin.json"), []byte(`{"id": "other", "types": {"dataProcessor": [{"type": "x"}]}}`), 0o600))
+	report, err = NewPluginManager(t.Context()).RegisterPlugins(t.Context(), dir, WithOnDemand(), WithRuntimeDir(root),
+		WithVerification(Verification{ChecksumFile: checksumFile}))
+	require.ErrorIs(t, err, ErrNoPluginsRegistered)
+	require.Len(t, report.Refused(), 1)
+	require.Equal(t, PhaseVerification, report.Refused()[0].Phase)
+	require.ErrorContains(t, report.Refused()[0].Err, "my-plugin.plugin.json is not listed in the checksum file")
+
+	_, err = pm.Shutdown(t.Context())
+	require.NoError(t, err)
+}
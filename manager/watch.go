@@ -0,0 +1,304 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Skarlso/go-plugin-framework/registry"
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// pluginFile is a plugin binary RegisterPlugins or Watch looked at.
+type pluginFile struct {
+	// id is the ID the plugin is registered with. It's empty if the plugin isn't registered.
+	id string
+	// version is the version of the binary that was verified and described last.
+	version fileVersion
+}
+
+// fileVersion identifies the version of a plugin binary.
+type fileVersion struct {
+	modTime  time.Time
+	size     int64
+	checksum string
+}
+
+// WithPollInterval configures how often Watch scans the plugin directory for changes. Defaults to 2 seconds.
+func WithPollInterval(d time.Duration) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.PollInterval = d
+	}
+}
+
+// WithDrainTimeout bounds how long Watch waits for the in-flight calls of a removed or replaced
+// plugin to finish before its process is stopped. Defaults to 30 seconds.
+func WithDrainTimeout(d time.Duration) RegistrationOptionFn {
+	return func(o *RegistrationOptions) {
+		o.DrainTimeout = d
+	}
+}
+
+// Watch polls dir for changes until ctx is done and keeps the registered plugins in sync with it.
+// New plugin binaries are registered with the given options. Plugins whose binary was removed are
+// drained and stopped. Plugins whose binary changed are replaced blue/green: the new version is
+// started and health checked before it takes the place of the old one, which is then drained and
+// stopped. Plugins registered from dir by RegisterPlugins before are picked up as they are.
+func (pm *PluginManager) Watch(ctx context.Context, dir string, opts ...RegistrationOptionFn) error {
+	reg, err := pm.newRegistration(opts)
+	if err != nil {
+		return err
+	}
+	reg.dir = dir
+
+	// Registrations done by Watch are not strict, a broken binary must not stop watching.
+	reg.opts.Strict = false
+
+	ticker := time.NewTicker(reg.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := pm.sync(ctx, reg, dir); err != nil {
+			slog.WarnContext(ctx, "failed to sync plugin directory", "dir", dir, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync compares the plugin binaries in dir with the known plugin files and registers,
+// replaces and unregisters plugins accordingly. pm.mu is not held while plugins are replaced
+// and drained, so a slow drain doesn't block the manager.
+func (pm *PluginManager) sync(ctx context.Context, reg *registration, dir string) error {
+	pm.syncMu.Lock()
+	defer pm.syncMu.Unlock()
+
+	plugins, err := pm.fetchPlugins(ctx, &reg.conf, dir)
+	if err != nil {
+		return fmt.Errorf("could not fetch plugins: %w", err)
+	}
+
+	added, changed, removed := pm.diffFiles(ctx, reg, dir, plugins)
+
+	for _, change := range changed {
+		pm.replace(ctx, reg, change.plugin, change.file, change.id)
+	}
+
+	for _, id := range removed {
+		if err := pm.unregister(ctx, reg, id); err != nil {
+			slog.WarnContext(ctx, "failed to unregister plugin", "id", id, "error", err)
+		}
+	}
+
+	if len(added) > 0 {
+		pm.mu.Lock()
+		report, err := pm.register(ctx, reg, added)
+		pm.mu.Unlock()
+		if err != nil && !errors.Is(err, ErrNoPluginsRegistered) && !errors.Is(err, ErrNoPluginsFound) {
+			return err
+		}
+
+		for _, entry := range report.Plugins {
+			if entry.Status == StatusRegistered {
+				slog.InfoContext(ctx, "new plugin registered", "id", entry.ID, "path", entry.Path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// changedFile is a known plugin file of a registered plugin whose binary changed.
+type changedFile struct {
+	plugin *types.Plugin
+	file   *pluginFile
+	// id is the ID the plugin was registered with when the change was seen.
+	id string
+}
+
+// diffFiles compares the plugin binaries found in dir with the known plugin files. It returns the
+// new binaries, the changed binaries of registered plugins and the IDs of the registered plugins
+// whose binary was removed. Removed files and changed files of plugins that failed before are
+// forgotten, so they are registered again as new binaries.
+func (pm *PluginManager) diffFiles(ctx context.Context, reg *registration, dir string, plugins []*types.Plugin) ([]*types.Plugin, []changedFile, []string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	var (
+		added   []*types.Plugin
+		changed []changedFile
+		removed []string
+	)
+	seen := make(map[string]bool, len(plugins))
+	for _, plugin := range plugins {
+		if !reg.opts.PluginFilter(plugin.ID) {
+			continue
+		}
+
+		key := fileKey(plugin.Path)
+		seen[key] = true
+
+		known, ok := pm.files[key]
+		if !ok {
+			added = append(added, plugin)
+			continue
+		}
+
+		version, err := readFileVersion(plugin.Path, known.version)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to check plugin binary", "path", plugin.Path, "error", err)
+			continue
+		}
+
+		switch {
+		case version.checksum == known.version.checksum:
+			// Only the modification time changed, remember it so the binary isn't hashed again.
+			known.version = version
+		case known.id == "":
+			// The plugin failed before, give the new version a chance.
+			delete(pm.files, key)
+			added = append(added, plugin)
+		default:
+			changed = append(changed, changedFile{plugin: plugin, file: known, id: known.id})
+		}
+	}
+
+	root := fileKey(dir) + string(filepath.Separator)
+	for key, file := range pm.files {
+		if seen[key] || !strings.HasPrefix(key, root) {
+			continue
+		}
+
+		delete(pm.files, key)
+		if file.id != "" {
+			slog.InfoContext(ctx, "plugin binary was removed, unregistering the plugin", "id", file.id, "path", key)
+			removed = append(removed, file.id)
+		}
+	}
+
+	return added, changed, removed
+}
+
+// replace registers the new version of a changed plugin binary in place of the old plugin with
+// the given ID. The caller must not hold pm.mu.
+func (pm *PluginManager) replace(ctx context.Context, reg *registration, plugin *types.Plugin, known *pluginFile, id string) {
+	entry := &PluginReport{ID: plugin.ID, Path: plugin.Path}
+	capabilities, version := pm.describeVerified(ctx, plugin, reg, entry)
+	// The changed binary is only looked at again once it changes again, even if it can't be used.
+	pm.updateFile(known, func(file *pluginFile) { file.version = version })
+	if capabilities == nil {
+		slog.WarnContext(ctx, "changed plugin binary can't be used, keeping the running version", "id", id, "path", entry.Path)
+		return
+	}
+
+	prepared, _, err := pm.preparePlugin(reg, *plugin, capabilities)
+	if err != nil {
+		slog.WarnContext(ctx, "changed plugin binary can't be used, keeping the running version", "id", id, "path", entry.Path, "error", err)
+		return
+	}
+
+	if prepared.ID != id {
+		// The ID of the plugin changed, so it's a different plugin now.
+		if err := pm.unregister(ctx, reg, id); err != nil {
+			slog.WarnContext(ctx, "failed to unregister plugin", "id", id, "error", err)
+		}
+		pm.updateFile(known, func(file *pluginFile) { file.id = "" })
+
+		if err := pm.Registry.AddExternalPlugins(ctx, []types.Plugin{prepared}, 1, reg.pluginOptions()...)[0]; err != nil {
+			slog.WarnContext(ctx, "failed to register changed plugin", "id", prepared.ID, "error", err)
+			return
+		}
+		pm.updateFile(known, func(file *pluginFile) { file.id = prepared.ID })
+
+		return
+	}
+
+	drainCtx, cancel := context.WithTimeout(ctx, reg.opts.DrainTimeout)
+	defer cancel()
+
+	if err := pm.Registry.ReplacePlugin(drainCtx, prepared, reg.pluginOptions()...); err != nil {
+		slog.WarnContext(ctx, "failed to replace plugin, keeping the running version", "id", id, "error", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "plugin replaced with its changed binary", "id", id, "path", entry.Path)
+}
+
+// updateFile calls fn with the known plugin file while holding pm.mu.
+func (pm *PluginManager) updateFile(file *pluginFile, fn func(file *pluginFile)) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	fn(file)
+}
+
+// unregister drains and stops a plugin, waiting at most the drain timeout.
+func (pm *PluginManager) unregister(ctx context.Context, reg *registration, id string) error {
+	drainCtx, cancel := context.WithTimeout(ctx, reg.opts.DrainTimeout)
+	defer cancel()
+
+	err := pm.Registry.UnregisterPlugin(drainCtx, id)
+	if errors.Is(err, registry.ErrPluginNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// readFileVersion returns the current version of the binary at path. The binary is only hashed
+// if its modification time or size differ from the known version.
+func readFileVersion(path string, known fileVersion) (fileVersion, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+
+	if info.ModTime().Equal(known.modTime) && info.Size() == known.size {
+		return known, nil
+	}
+
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+
+	return fileVersion{modTime: info.ModTime(), size: info.Size(), checksum: checksum}, nil
+}
+
+// fileChecksum returns the hex encoded SHA-256 checksum of the file.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileKey returns the absolute path files are tracked by.
+func fileKey(path string) string {
+	abs, err := filepath.Abs(cleanPath(path))
+	if err != nil {
+		return filepath.Clean(path)
+	}
+
+	return abs
+}
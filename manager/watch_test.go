@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/registry"
+)
+
+func capabilitiesScript(subType string) string {
+	return `echo '{"types": {"dataProcessor": [{"type": "` + subType + `"}]}}'; exit 0`
+}
+
+func TestWatchSync(t *testing.T) {
+	dir := t.TempDir()
+	writeScriptPlugin(t, dir, "a-plugin", capabilitiesScript("first"), "exit 1")
+
+	pm := NewPluginManager(t.Context())
+	_, err := pm.RegisterPlugins(t.Context(), dir, WithOnDemand())
+	require.NoError(t, err)
+
+	reg, err := newRegistration([]RegistrationOptionFn{WithOnDemand()})
+	require.NoError(t, err)
+
+	// The plugin registered before is picked up as it is.
+	require.NoError(t, pm.sync(t.Context(), reg, dir))
+	require.Equal(t, []registry.TypeEntry{
+		{Type: "dataProcessor", SubType: "first", PluginID: "a-plugin"},
+	}, pm.ListTypes())
+
+	// New binaries are registered.
+	writeScriptPlugin(t, dir, "b-plugin", capabilitiesScript("second"), "exit 1")
+	require.NoError(t, pm.sync(t.Context(), reg, dir))
+	require.Equal(t, []registry.TypeEntry{
+		{Type: "dataProcessor", SubType: "first", PluginID: "a-plugin"},
+		{Type: "dataProcessor", SubType: "second", PluginID: "b-plugin"},
+	}, pm.ListTypes())
+
+	// Changed binaries replace the registered plugin.
+	writeScriptPlugin(t, dir, "a-plugin", capabilitiesScript("first-changed"), "exit 1")
+	require.NoError(t, pm.sync(t.Context(), reg, dir))
+	require.Equal(t, []registry.TypeEntry{
+		{Type: "dataProcessor", SubType: "first-changed", PluginID: "a-plugin"},
+		{Type: "dataProcessor", SubType: "second", PluginID: "b-plugin"},
+	}, pm.ListTypes())
+
+	// The replacement keeps the position of the old plugin.
+	plugins := pm.GetPlugins(t.Context(), "dataProcessor")
+	require.Equal(t, "a-plugin", plugins[0].(*registry.ExternalPluginWrapper).GetID())
+
+	// Removed binaries are unregistered.
+	require.NoError(t, os.Remove(filepath.Join(dir, "b-plugin")))
+	require.NoError(t, pm.sync(t.Context(), reg, dir))
+	require.Equal(t, []registry.TypeEntry{
+		{Type: "dataProcessor", SubType: "first-changed", PluginID: "a-plugin"},
+	}, pm.ListTypes())
+
+	// A broken binary is retried once it changes.
+	writeScriptPlugin(t, dir, "c-plugin", "exit 1", "exit 1")
+	require.NoError(t, pm.sync(t.Context(), reg, dir))
+	require.Len(t, pm.ListTypes(), 1)
+
+	writeScriptPlugin(t, dir, "c-plugin", capabilitiesScript("third"), "exit 1")
+	require.NoError(t, pm.sync(t.Context(), reg, dir))
+	require.Len(t, pm.ListTypes(), 2)
+
+	// Plugins unregistered explicitly stay unregistered while their binary is unchanged.
+	var events []registry.EventType
+	pm.AddEventHandler(func(event registry.Event) {
+		events = append(events, event.Type)
+	})
+	require.NoError(t, pm.UnregisterPlugin(t.Context(), "a-plugin"))
+	require.NoError(t, pm.sync(t.Context(), reg, dir))
+	require.Equal(t, []registry.TypeEntry{
+		{Type: "dataProcessor", SubType: "third", PluginID: "c-plugin"},
+	}, pm.ListTypes())
+	require.Equal(t, []registry.EventType{registry.EventDraining, registry.EventUnregistered}, events)
+}
+
+func TestWatchSyncChangedSinceRegistration(t *testing.T) {
+	dir := t.TempDir()
+	writeScriptPlugin(t, dir, "a-plugin", capabilitiesScript("first"), "exit 1")
+
+	pm := NewPluginManager(t.Context())
+	_, err := pm.RegisterPlugins(t.Context(), dir, WithOnDemand())
+	require.NoError(t, err)
+
+	reg, err := newRegistration([]RegistrationOptionFn{WithOnDemand()})
+	require.NoError(t, err)
+
+	// The binary changed before Watch saw it the first time.
+	writeScriptPlugin(t, dir, "a-plugin", capabilitiesScript("changed"), "exit 1")
+	require.NoError(t, pm.sync(t.Context(), reg, dir))
+	require.Equal(t, []registry.TypeEntry{
+		{Type: "dataProcessor", SubType: "changed", PluginID: "a-plugin"},
+	}, pm.ListTypes())
+}
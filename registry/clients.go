@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Skarlso/go-plugin-framework/contracts"
+)
+
+func init() {
+	RegisterClient(func(w *ExternalPluginWrapper) contracts.DataProcessor {
+		return NewDataProcessorClient(w)
+	})
+	RegisterClient(func(w *ExternalPluginWrapper) contracts.Transformer {
+		return NewTransformerClient(w)
+	})
+}
+
+// DataProcessorClient implements contracts.DataProcessor on top of an external plugin.
+type DataProcessorClient struct {
+	*ExternalPluginWrapper
+}
+
+var _ contracts.DataProcessor = (*DataProcessorClient)(nil)
+
+// NewDataProcessorClient creates a DataProcessor client for the external plugin.
+func NewDataProcessorClient(w *ExternalPluginWrapper) *DataProcessorClient {
+	return &DataProcessorClient{ExternalPluginWrapper: w}
+}
+
+// ProcessData implements contracts.DataProcessor.
+func (c *DataProcessorClient) ProcessData(ctx context.Context, input []byte) ([]byte, error) {
+	response, err := ProcessDataEndpoint.Invoke(ctx, c.ExternalPluginWrapper, contracts.DataProcessorRequest{
+		Data:   input,
+		Format: "text/plain",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Data, nil
+}
+
+// GetSupportedFormats implements contracts.DataProcessor.
+func (c *DataProcessorClient) GetSupportedFormats(ctx context.Context) ([]string, error) {
+	response, err := SupportedFormatsEndpoint.Invoke(ctx, c.ExternalPluginWrapper, contracts.Empty{})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Formats, nil
+}
+
+// TransformerClient implements contracts.Transformer on top of an external plugin. Parameters
+// of transform requests are validated against the transformations the plugin declares before
+// they are sent.
+type TransformerClient struct {
+	*ExternalPluginWrapper
+
+	mu sync.Mutex
+	// transformations caches the transformations declared by the plugin by name.
+	transformations map[string]contracts.TransformationInfo
+	// generation is the process generation of the plugin the transformations were fetched from.
+	generation uint64
+}
+
+var _ contracts.Transformer = (*TransformerClient)(nil)
+
+// NewTransformerClient creates a Transformer client for the external plugin.
+func NewTransformerClient(w *ExternalPluginWrapper) *TransformerClient {
+	return &TransformerClient{ExternalPluginWrapper: w}
+}
+
+// Transform implements contracts.Transformer. Missing optional parameters are filled in
+// with their declared defaults. The passed request is not modified.
+func (c *TransformerClient) Transform(ctx context.Context, request *contracts.TransformRequest) (*contracts.TransformResponse, error) {
+	info, err := c.transformation(ctx, request.Transformation)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := info.ValidateParameters(request.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	validated := *request
+	validated.Parameters = params
+
+	response, err := TransformEndpoint.Invoke(ctx, c.ExternalPluginWrapper, validated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// GetTransformations implements contracts.Transformer.
+func (c *TransformerClient) GetTransformations(ctx context.Context) ([]contracts.TransformationInfo, error) {
+	response, err := TransformationsEndpoint.Invoke(ctx, c.ExternalPluginWrapper, contracts.Empty{})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Transformations, nil
+}
+
+// transformation returns the declared transformation with the given name. The declared
+// transformations are fetched once per plugin process and fetched again if the name isn't
+// known, as a restarted or reconfigured plugin may declare others.
+func (c *TransformerClient) transformation(ctx context.Context, name string) (contracts.TransformationInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	generation := c.processGeneration()
+	if generation != c.generation {
+		c.transformations = nil
+	}
+
+	if info, ok := c.transformations[name]; ok {
+		return info, nil
+	}
+
+	transformations, err := c.GetTransformations(ctx)
+	if err != nil {
+		return contracts.TransformationInfo{}, fmt.Errorf("failed to get transformations: %w", err)
+	}
+
+	// A process started during the call only causes another fetch.
+	c.generation = generation
+	c.transformations = make(map[string]contracts.TransformationInfo, len(transformations))
+	for _, info := range transformations {
+		c.transformations[info.Name] = info
+	}
+
+	info, ok := c.transformations[name]
+	if !ok {
+		return contracts.TransformationInfo{}, fmt.Errorf("plugin %s does not offer transformation %q", c.plugin.ID, name)
+	}
+
+	return info, nil
+}
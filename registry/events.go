@@ -0,0 +1,64 @@
+package registry
+
+import "time"
+
+// EventType identifies what happened to a plugin.
+type EventType string
+
+const (
+	// EventRegistered is fired when an external plugin is added to the registry.
+	EventRegistered EventType = "registered"
+	// EventStarted is fired when a plugin process passed its health check.
+	EventStarted EventType = "started"
+	// EventIdle is fired when a plugin process stopped itself because it was idle.
+	EventIdle EventType = "idle"
+	// EventExited is fired when a plugin process exited for any other reason. Err holds the exit error.
+	EventExited EventType = "exited"
+	// EventRestarted is fired when a plugin process was restarted by its restart policy or RestartPlugin.
+	EventRestarted EventType = "restarted"
+	// EventConfigReloaded is fired when a plugin was restarted with new configuration data.
+	EventConfigReloaded EventType = "config-reloaded"
+	// EventReplaced is fired when a new version of a plugin took the place of the old one.
+	EventReplaced EventType = "replaced"
+	// EventDraining is fired when the registry starts to wait for the in-flight calls of a plugin.
+	EventDraining EventType = "draining"
+	// EventStopped is fired when the registry stopped a plugin process.
+	EventStopped EventType = "stopped"
+	// EventUnregistered is fired when an external plugin is removed from the registry.
+	EventUnregistered EventType = "unregistered"
+)
+
+// Event describes a change in the lifecycle of an external plugin.
+type Event struct {
+	// Type is what happened.
+	Type EventType
+	// PluginID is the ID of the plugin.
+	PluginID string
+	// Time is when it happened.
+	Time time.Time
+	// Err holds the error that caused the event, if any.
+	Err error
+}
+
+// EventHandler is called for every lifecycle event.
+type EventHandler func(Event)
+
+// AddEventHandler registers a handler for the lifecycle events of all external plugins. Handlers are
+// called synchronously in the order the events happen, so they must not block or call the registry.
+func (r *Registry) AddEventHandler(handler EventHandler) {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+
+	r.handlers = append(r.handlers, handler)
+}
+
+// emit calls every registered event handler.
+func (r *Registry) emit(eventType EventType, id string, err error) {
+	r.eventsMu.RLock()
+	defer r.eventsMu.RUnlock()
+
+	event := Event{Type: eventType, PluginID: id, Time: time.Now(), Err: err}
+	for _, handler := range r.handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,247 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// ErrPluginNotFound is returned if no external plugin is registered with the given ID.
+var ErrPluginNotFound = errors.New("plugin not found")
+
+// drainInterval is how often the number of in-flight calls is checked while draining a plugin.
+const drainInterval = 50 * time.Millisecond
+
+// UnregisterPlugin removes the external plugin with the given ID from every type it provides.
+// Calls that are in flight are drained before the plugin process is stopped. If ctx is done
+// before that, the process is killed.
+func (r *Registry) UnregisterPlugin(ctx context.Context, id string) error {
+	r.mu.Lock()
+	ext, ok := r.pluginsByID[id]
+	if !ok {
+		r.mu.Unlock()
+		return fmt.Errorf("external plugin with id %q: %w", id, ErrPluginNotFound)
+	}
+	r.removeLocked(ext)
+	r.mu.Unlock()
+
+	err := r.retire(ctx, ext)
+	r.emit(EventUnregistered, id, err)
+
+	return err
+}
+
+// RestartPlugin drains the external plugin with the given ID, stops its process and starts
+// a new one. Clients of the plugin keep working; calls made during the restart wait for the
+// new process. If ctx is done before the calls are drained and the process stopped, the
+// process is killed.
+func (r *Registry) RestartPlugin(ctx context.Context, id string) error {
+	ext, err := r.external(id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.restartPlugin(ctx, ext, false, nil); err != nil {
+		return err
+	}
+
+	r.emit(EventRestarted, id, nil)
+
+	return nil
+}
+
+// ReloadConfig restarts the external plugin with the given ID like RestartPlugin, passing
+// configData to the new process instead of the configuration data it was started with. If the
+// new process fails to start, the plugin is started again with its previous configuration data
+// and the error of the failed start is returned.
+func (r *Registry) ReloadConfig(ctx context.Context, id string, configData []types.ConfigData) error {
+	ext, err := r.external(id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.restartPlugin(ctx, ext, true, configData); err != nil {
+		return err
+	}
+
+	r.emit(EventConfigReloaded, id, nil)
+
+	return nil
+}
+
+// external returns the external plugin with the given ID.
+func (r *Registry) external(id string) (*ExternalPlugin, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ext, ok := r.pluginsByID[id]
+	if !ok {
+		return nil, fmt.Errorf("external plugin with id %q: %w", id, ErrPluginNotFound)
+	}
+
+	return ext, nil
+}
+
+// restartPlugin drains and stops the plugin process and starts a new one. With reload, the new
+// process gets configData instead of the configuration data the plugin was started with. A plugin
+// that isn't running is only reconfigured and started. If the reconfigured plugin fails to start,
+// it's started again with its previous configuration data. Plugins that were unregistered,
+// replaced or shut down meanwhile are not started again.
+func (r *Registry) restartPlugin(ctx context.Context, ext *ExternalPlugin, reload bool, configData []types.ConfigData) error {
+	r.emit(EventDraining, ext.Plugin.ID, nil)
+	if err := r.drain(ctx, ext); err != nil {
+		slog.WarnContext(ctx, "plugin still has calls in flight, restarting it anyway", "id", ext.Plugin.ID, "in-flight", ext.wrapper.InFlight())
+	}
+
+	ext.startMu.Lock()
+	defer ext.startMu.Unlock()
+
+	if result := r.stopLocked(ctx, ext); result.Err != nil {
+		return result.Err
+	}
+
+	if ext.retired.Load() {
+		return fmt.Errorf("external plugin with id %q was removed while being restarted: %w", ext.Plugin.ID, ErrPluginNotFound)
+	}
+
+	previous := ext.Plugin.Config.ConfigTypes
+	if reload {
+		r.setConfigTypes(ext, configData)
+	}
+
+	ext.stopping.Store(false)
+	ext.wrapper.markExited(stateNotStarted, nil)
+
+	err := r.startLocked(ctx, ext)
+	if err == nil || !reload {
+		return err
+	}
+
+	slog.WarnContext(ctx, "plugin failed to start with the new configuration, starting it as it was", "id", ext.Plugin.ID, "error", err)
+	r.setConfigTypes(ext, previous)
+	ext.wrapper.markExited(stateNotStarted, nil)
+	if rollbackErr := r.startLocked(ctx, ext); rollbackErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to start the plugin as it was: %w", rollbackErr))
+	}
+
+	return err
+}
+
+// setConfigTypes sets the configuration data the plugin processes are started with. The caller
+// has to hold ext.startMu, which new processes are started under.
+func (r *Registry) setConfigTypes(ext *ExternalPlugin, configData []types.ConfigData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ext.Plugin.Config.ConfigTypes = configData
+}
+
+// ReplacePlugin replaces the external plugin with the same ID with a new version. The new plugin
+// is started and health checked first, unless it's registered with WithLazyStart. Only then it
+// takes the place of the old plugin for every type, and the old plugin is drained and stopped.
+// If the new plugin fails to start, the old plugin keeps serving. Callers holding the client
+// of the old plugin have to look the plugin up again.
+func (r *Registry) ReplacePlugin(ctx context.Context, plugin types.Plugin, opts ...ExternalPluginOptionFn) error {
+	options := newExternalPluginOptions(opts)
+
+	// The replacement runs next to the old plugin for a while, so it must not use its files.
+	plugin.Config.Instance = strconv.FormatUint(r.instances.Add(1), 10)
+
+	r.mu.RLock()
+	_, ok := r.pluginsByID[plugin.ID]
+	replacement, err := r.newExternalPlugin(plugin, options)
+	r.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("external plugin with id %q: %w", plugin.ID, ErrPluginNotFound)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !options.LazyStart {
+		if err := r.ensureStarted(ctx, replacement); err != nil {
+			return fmt.Errorf("failed to start the replacement of plugin %s: %w", plugin.ID, err)
+		}
+	}
+
+	r.mu.Lock()
+	old, ok := r.pluginsByID[plugin.ID]
+	if !ok {
+		r.mu.Unlock()
+		_ = r.retire(ctx, replacement)
+
+		return fmt.Errorf("external plugin with id %q was removed while being replaced: %w", plugin.ID, ErrPluginNotFound)
+	}
+	r.swapLocked(old, replacement)
+	r.mu.Unlock()
+
+	slog.InfoContext(ctx, "plugin replaced", "id", plugin.ID)
+	r.emit(EventReplaced, plugin.ID, nil)
+
+	return r.retire(ctx, old)
+}
+
+// swapLocked puts replacement in the place of old. Types both provide keep their position, so the
+// replacement keeps the old plugin's priority. The caller has to hold r.mu.
+func (r *Registry) swapLocked(old, replacement *ExternalPlugin) {
+	r.pluginsByID[replacement.Plugin.ID] = replacement
+
+	for pluginType := range old.Plugin.Types {
+		if _, ok := replacement.Plugin.Types[pluginType]; ok {
+			continue
+		}
+
+		r.externalPlugins[pluginType] = slices.DeleteFunc(r.externalPlugins[pluginType], func(p *ExternalPlugin) bool {
+			return p == old
+		})
+		if len(r.externalPlugins[pluginType]) == 0 {
+			delete(r.externalPlugins, pluginType)
+		}
+	}
+
+	for pluginType := range replacement.Plugin.Types {
+		i := slices.Index(r.externalPlugins[pluginType], old)
+		if i < 0 {
+			r.externalPlugins[pluginType] = append(r.externalPlugins[pluginType], replacement)
+			continue
+		}
+		r.externalPlugins[pluginType][i] = replacement
+	}
+}
+
+// retire drains a plugin that is no longer registered and stops its process.
+func (r *Registry) retire(ctx context.Context, ext *ExternalPlugin) error {
+	ext.retired.Store(true)
+	ext.stopping.Store(true)
+
+	r.emit(EventDraining, ext.Plugin.ID, nil)
+	if err := r.drain(ctx, ext); err != nil {
+		slog.WarnContext(ctx, "plugin still has calls in flight, stopping it anyway", "id", ext.Plugin.ID, "in-flight", ext.wrapper.InFlight())
+	}
+
+	return r.stop(ctx, ext).Err
+}
+
+// drain waits until the plugin has no calls in flight or ctx is done.
+func (r *Registry) drain(ctx context.Context, ext *ExternalPlugin) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for ext.wrapper.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
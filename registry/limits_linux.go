@@ -0,0 +1,203 @@
+//go:build linux
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// cpuPeriod is the period of the cgroup CPU bandwidth limit in microseconds.
+const cpuPeriod = 100000
+
+// processLimits applies the resource limits of a plugin to one of its processes.
+type processLimits struct {
+	limits *types.ResourceLimits
+	// cgroup is the directory of the cgroup the process runs in. It's empty if the process runs in the host's cgroup.
+	cgroup   string
+	cgroupFD *os.File
+}
+
+// newProcessLimits prepares the limits for a new process of the plugin. If parent is set and a
+// writable cgroup v2, a cgroup is created for the process below it. Otherwise only the rlimits
+// are applied.
+func newProcessLimits(plugin *types.Plugin, parent string) (*processLimits, error) {
+	l := &processLimits{limits: plugin.Resources}
+	if parent == "" || plugin.Resources == nil {
+		return l, nil
+	}
+
+	name := plugin.Config.ID
+	if plugin.Config.Instance != "" {
+		name += "-" + plugin.Config.Instance
+	}
+
+	if err := l.createCgroup(filepath.Join(parent, name)); err != nil {
+		slog.Warn("cgroup can't be used, applying only rlimits", "id", plugin.ID, "parent", parent, "error", err)
+		l.release()
+	}
+
+	return l, nil
+}
+
+// createCgroup creates the cgroup for the process and writes its limits.
+func (l *processLimits) createCgroup(dir string) error {
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "cgroup.controllers")); err != nil {
+		return fmt.Errorf("not a cgroup v2 directory: %w", err)
+	}
+
+	// A cgroup left behind by a process that wasn't cleaned up is replaced.
+	_ = os.Remove(dir)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return err
+	}
+	l.cgroup = dir
+
+	// Enabling the controllers fails if they already are or can't be; writing the limits tells.
+	_ = os.WriteFile(filepath.Join(filepath.Dir(dir), "cgroup.subtree_control"), []byte("+memory +cpu +pids"), 0)
+
+	files := map[string]string{}
+	if l.limits.MemoryBytes > 0 {
+		files["memory.max"] = strconv.FormatUint(l.limits.MemoryBytes, 10)
+	}
+	if l.limits.CPUs > 0 {
+		files["cpu.max"] = fmt.Sprintf("%d %d", uint64(l.limits.CPUs*cpuPeriod), cpuPeriod)
+	}
+	if l.limits.Processes > 0 {
+		files["pids.max"] = strconv.FormatUint(l.limits.Processes, 10)
+	}
+
+	for file, value := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0); err != nil {
+			return fmt.Errorf("failed to set %s: %w", file, err)
+		}
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	l.cgroupFD = fd
+
+	return nil
+}
+
+// shell runs the shim that sets the rlimits of a plugin process before the plugin binary is executed.
+const shell = "/bin/sh"
+
+// prepare places the process in the cgroup when it's started and makes it set its rlimits before
+// the plugin binary runs. Processes with rlimits are started as a shell that sets them with
+// ulimit and then executes the plugin binary in its place, so the plugin never runs without its
+// limits. It has to be called after setProcessAttributes.
+func (l *processLimits) prepare(cmd *exec.Cmd) {
+	if l.cgroupFD != nil {
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(l.cgroupFD.Fd())
+	}
+
+	if script := l.ulimits(); script != "" {
+		cmd.Args = append([]string{shell, "-c", script + `exec "$0" "$@"`, cmd.Path}, cmd.Args[1:]...)
+		cmd.Path = shell
+	}
+}
+
+// ulimits returns the shell commands that set the rlimits of the process, each followed by "&&".
+func (l *processLimits) ulimits() string {
+	if l.limits == nil {
+		return ""
+	}
+
+	var script strings.Builder
+	if l.limits.AddressSpaceBytes > 0 {
+		// ulimit takes the size of the address space in KiB.
+		fmt.Fprintf(&script, "ulimit -v %d && ", max(l.limits.AddressSpaceBytes/1024, 1))
+	}
+	if l.limits.OpenFiles > 0 {
+		fmt.Fprintf(&script, "ulimit -n %d && ", l.limits.OpenFiles)
+	}
+	if l.limits.CPUSeconds > 0 {
+		// The process is killed a second after SIGXCPU, which Go programs ignore. The soft limit
+		// is set first, the hard limit can't be set below it.
+		fmt.Fprintf(&script, "ulimit -S -t %d && ulimit -H -t %d && ", l.limits.CPUSeconds, l.limits.CPUSeconds+1)
+	}
+
+	return script.String()
+}
+
+// exitReason adds the limit the process exceeded to its exit error, if it exceeded any.
+func (l *processLimits) exitReason(cmd *exec.Cmd, exitErr error) error {
+	if l.limits == nil || exitErr == nil {
+		return exitErr
+	}
+
+	if reason := l.exceeded(cmd.ProcessState); reason != "" {
+		return fmt.Errorf("%w: %s: %w", ErrLimitExceeded, reason, exitErr)
+	}
+
+	return exitErr
+}
+
+// exceeded returns the limit the process exceeded, or an empty string.
+func (l *processLimits) exceeded(state *os.ProcessState) string {
+	if l.cgroup != "" {
+		if cgroupEvent(filepath.Join(l.cgroup, "memory.events"), "oom_kill") > 0 {
+			return fmt.Sprintf("memory limit of %d bytes", l.limits.MemoryBytes)
+		}
+		if cgroupEvent(filepath.Join(l.cgroup, "pids.events"), "max") > 0 {
+			return fmt.Sprintf("process limit of %d", l.limits.Processes)
+		}
+	}
+
+	if l.limits.CPUSeconds > 0 && state != nil {
+		// Processes ignoring SIGXCPU are killed once they used up the hard limit.
+		status, _ := state.Sys().(syscall.WaitStatus)
+		used := state.UserTime() + state.SystemTime()
+		if status.Signaled() && status.Signal() == syscall.SIGXCPU || used >= time.Duration(l.limits.CPUSeconds)*time.Second {
+			return fmt.Sprintf("CPU time limit of %d seconds", l.limits.CPUSeconds)
+		}
+	}
+
+	return ""
+}
+
+// cgroupEvent reads a counter from a cgroup events file. It returns 0 if the file can't be read.
+func cgroupEvent(path, key string) uint64 {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	for line := range strings.SplitSeq(string(content), "\n") {
+		if name, value, ok := strings.Cut(line, " "); ok && name == key {
+			count, _ := strconv.ParseUint(value, 10, 64)
+			return count
+		}
+	}
+
+	return 0
+}
+
+// release removes the cgroup of the process once it has exited.
+func (l *processLimits) release() {
+	if l.cgroupFD != nil {
+		_ = l.cgroupFD.Close()
+		l.cgroupFD = nil
+	}
+
+	if l.cgroup != "" {
+		if err := os.Remove(l.cgroup); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to remove plugin cgroup", "cgroup", l.cgroup, "error", err)
+		}
+		l.cgroup = ""
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+package registry
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// processLimits applies the resource limits of a plugin to one of its processes. Limits are only
+// supported on Linux.
+type processLimits struct{}
+
+// newProcessLimits fails if the plugin has resource limits, so it doesn't run unconstrained.
+func newProcessLimits(plugin *types.Plugin, _ string) (*processLimits, error) {
+	if plugin.Resources != nil && *plugin.Resources != (types.ResourceLimits{}) {
+		return nil, errors.New("resource limits are only supported on Linux")
+	}
+
+	return &processLimits{}, nil
+}
+
+func (l *processLimits) prepare(*exec.Cmd) {}
+
+func (l *processLimits) exitReason(_ *exec.Cmd, exitErr error) error {
+	return exitErr
+}
+
+func (l *processLimits) release() {}
@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/contracts"
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// testPluginEnv makes the test binary run as a plugin instead of running the tests. Its value is
+// the mode the plugin runs in.
+const testPluginEnv = "REGISTRY_TEST_PLUGIN"
+
+const (
+	// testPluginServe serves the data processor contract and exits when asked to shut down.
+	testPluginServe = "serve"
+	// testPluginIgnoreShutdown doesn't serve the shutdown endpoint, so it has to be terminated.
+	testPluginIgnoreShutdown = "ignore-shutdown"
+	// testPluginIgnoreTerm doesn't serve the shutdown endpoint and ignores SIGTERM, so it has to be killed.
+	testPluginIgnoreTerm = "ignore-term"
+)
+
+// testPluginFailConfig is the config type that makes the test plugin exit before its handshake.
+const testPluginFailConfig = "fail"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(testPluginEnv); mode != "" {
+		if err := runTestPlugin(mode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// testPlugin returns a plugin that runs the test binary as a plugin in the given mode. The
+// shell commands in prelude are run by the plugin process first.
+func testPlugin(t *testing.T, id, mode, prelude string) types.Plugin {
+	t.Helper()
+
+	executable, err := os.Executable()
+	require.NoError(t, err)
+
+	// Binaries built with -race sleep for a second before exiting unless told otherwise.
+	path := filepath.Join(t.TempDir(), id)
+	script := fmt.Sprintf("#!/bin/sh\n%s\nGORACE=atexit_sleep_ms=0 %s=%s exec '%s' \"$@\"\n", prelude, testPluginEnv, mode, executable)
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o700))
+
+	return types.Plugin{
+		ID:     id,
+		Path:   path,
+		Config: types.Config{ID: id, Type: types.TCP},
+		Types:  map[string][]types.TypeInfo{"dataProcessor": {{Type: id}}},
+	}
+}
+
+// runTestPlugin serves a data processor that upper-cases its data like an SDK plugin would.
+func runTestPlugin(mode string) error {
+	if len(os.Args) != 3 || os.Args[1] != "--config" {
+		return fmt.Errorf("unexpected arguments %v", os.Args[1:])
+	}
+
+	var config types.Config
+	if err := json.Unmarshal([]byte(os.Args[2]), &config); err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(config.ConfigTypes, func(data types.ConfigData) bool { return data.Type == testPluginFailConfig }) {
+		return errors.New("the configuration asks the plugin to fail")
+	}
+
+	network, address := "tcp", "127.0.0.1:0"
+	if config.Type == types.Socket {
+		network, address = "unix", filepath.Join(config.RuntimeDir, config.ID+config.Instance+".socket")
+		if err := os.WriteFile(address+".lock", []byte(fmt.Sprint(os.Getpid())), 0o600); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+
+	location := "http://" + listener.Addr().String()
+	if config.Type == types.Socket {
+		location = "http+unix://" + address
+	}
+
+	if mode == testPluginIgnoreTerm {
+		signal.Ignore(syscall.SIGTERM)
+	}
+
+	done := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc("GET /formats", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(contracts.SupportedFormatsResponse{Formats: []string{"text"}})
+	})
+	mux.HandleFunc("POST /process", func(w http.ResponseWriter, r *http.Request) {
+		var request contracts.DataProcessorRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(contracts.DataProcessorResponse{Data: []byte(strings.ToUpper(string(request.Data)))})
+	})
+	if mode == testPluginServe {
+		var once sync.Once
+		mux.HandleFunc("POST /shutdown", func(http.ResponseWriter, *http.Request) {
+			once.Do(func() { close(done) })
+		})
+	}
+
+	handshake, err := json.Marshal(types.Handshake{
+		ProtocolVersion: types.ProtocolVersion,
+		SDKVersion:      "test",
+		Location:        location,
+		PID:             os.Getpid(),
+		Transports:      []types.ConnectionType{types.TCP, types.Socket},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(types.HandshakePrefix + string(handshake))
+
+	server := &http.Server{Handler: mux} //nolint:gosec // G112: only reached by the tests
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	<-done
+
+	if config.Type == types.Socket {
+		_ = os.Remove(address + ".lock")
+	}
+
+	// Shutdown waits for the response to the shutdown request to be written.
+	return server.Shutdown(context.Background())
+}
@@ -0,0 +1,213 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultOutputLines is the number of recent output lines kept per plugin.
+	defaultOutputLines = 200
+	// maxLineLength is the length after which a line without a newline is routed anyway.
+	maxLineLength = 64 * 1024
+)
+
+// Streams a plugin process writes to.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// OutputLine is a line a plugin process wrote to stdout or stderr.
+type OutputLine struct {
+	// Time is when the line was read.
+	Time time.Time
+	// Stream is StreamStdout or StreamStderr.
+	Stream string
+	// PID is the process ID of the plugin process that wrote the line.
+	PID int
+	// Text is the line without the trailing newline.
+	Text string
+}
+
+// pluginOutput routes the output of a plugin's processes into the host's logger and keeps the
+// most recent lines in a ring buffer.
+type pluginOutput struct {
+	id string
+	// logger logs the lines. The default logger is used if it's nil.
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	lines []OutputLine
+	// next is the position the next line is written to once the buffer is full.
+	next int
+}
+
+func newPluginOutput(id string, size int, logger *slog.Logger) *pluginOutput {
+	if size <= 0 {
+		size = defaultOutputLines
+	}
+
+	return &pluginOutput{id: id, logger: logger, lines: make([]OutputLine, 0, size)}
+}
+
+// writer returns a writer for one stream of the plugin process run by cmd.
+func (o *pluginOutput) writer(stream string, cmd *exec.Cmd) *lineWriter {
+	return &lineWriter{route: func(text string) {
+		if text == "" {
+			return
+		}
+
+		pid := 0
+		if cmd.Process != nil {
+			pid = cmd.Process.Pid
+		}
+
+		o.route(OutputLine{Time: time.Now(), Stream: stream, PID: pid, Text: text})
+	}}
+}
+
+// forward routes the lines read from r until the plugin process and its subprocesses closed it.
+// Closing r is up to the caller.
+func (o *pluginOutput) forward(stream string, cmd *exec.Cmd, r io.Reader) {
+	w := o.writer(stream, cmd)
+	_, _ = io.Copy(w, r)
+	w.flush()
+}
+
+// flushOutput routes the last line the plugin process wrote to stderr if it didn't end with a
+// newline. It has to be called after the process was waited for.
+func flushOutput(cmd *exec.Cmd) {
+	if w, ok := cmd.Stderr.(*lineWriter); ok {
+		w.flush()
+	}
+}
+
+// route logs the line and adds it to the ring buffer. Lines written by slog's JSON handler are
+// logged with their level, message and attributes; other lines are logged verbatim. The
+// attributes of the plugin are put in the attrs group, so they can't be taken for the
+// plugin_id, pid and stream attributes the line is logged with.
+func (o *pluginOutput) route(line OutputLine) {
+	o.add(line)
+
+	logger := o.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("plugin_id", o.id, "pid", line.PID, "stream", line.Stream)
+
+	level, message, record, ok := parseRecord(line.Text)
+	if !ok {
+		logger.Info("plugin output", "line", line.Text)
+		return
+	}
+
+	attrs := make([]any, 0, 2*len(record))
+	for _, key := range slices.Sorted(maps.Keys(record)) {
+		attrs = append(attrs, key, record[key])
+	}
+
+	logger.Log(context.Background(), level, message, slog.Group("attrs", attrs...))
+}
+
+// parseRecord parses a line written by slog's JSON handler and returns its level, message and
+// attributes. Other lines, including JSON without the level and message of a record like a bare
+// null or an object the plugin printed, are reported as not being a record.
+func parseRecord(text string) (slog.Level, string, map[string]any, bool) {
+	var record map[string]any
+	if err := json.Unmarshal([]byte(text), &record); err != nil {
+		return 0, "", nil, false
+	}
+
+	levelText, hasLevel := record[slog.LevelKey].(string)
+	message, hasMessage := record[slog.MessageKey].(string)
+	var level slog.Level
+	if !hasLevel || !hasMessage || level.UnmarshalText([]byte(levelText)) != nil {
+		return 0, "", nil, false
+	}
+
+	delete(record, slog.TimeKey)
+	delete(record, slog.LevelKey)
+	delete(record, slog.MessageKey)
+
+	return level, message, record, true
+}
+
+// add puts the line into the ring buffer, overwriting the oldest line once it's full.
+func (o *pluginOutput) add(line OutputLine) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.lines) < cap(o.lines) {
+		o.lines = append(o.lines, line)
+		return
+	}
+
+	o.lines[o.next] = line
+	o.next = (o.next + 1) % len(o.lines)
+}
+
+// recent returns the lines in the ring buffer, oldest first.
+func (o *pluginOutput) recent() []OutputLine {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	recent := make([]OutputLine, 0, len(o.lines))
+	recent = append(recent, o.lines[o.next:]...)
+
+	return append(recent, o.lines[:o.next]...)
+}
+
+// lineWriter splits what is written to it into lines.
+type lineWriter struct {
+	route   func(string)
+	partial []byte
+}
+
+// Write implements io.Writer.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.route(string(bytes.TrimSuffix(w.partial[:i], []byte("\r"))))
+		w.partial = w.partial[i+1:]
+	}
+
+	if len(w.partial) > maxLineLength {
+		w.flush()
+	}
+
+	return len(p), nil
+}
+
+// flush routes what was written after the last newline.
+func (w *lineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.route(string(w.partial))
+		w.partial = nil
+	}
+}
+
+// PluginOutput returns the most recent lines the processes of the external plugin with the given
+// ID wrote to stdout and stderr, oldest first.
+func (r *Registry) PluginOutput(id string) ([]OutputLine, error) {
+	ext, err := r.external(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ext.output.recent(), nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package plugins
+
+// AbstractSocketsSupported reports whether plugins can listen on Unix sockets in the abstract
+// namespace, see types.AbstractSocket. The abstract namespace only exists on Linux.
+const AbstractSocketsSupported = false
@@ -25,6 +25,14 @@ type CallOptions struct {
 	Result      any
 	Headers     []KV
 	QueryParams []KV
+	// SchemaType and SchemaSubType name the type and sub-type whose JSON schema the payload is
+	// validated against.
+	SchemaType    string
+	SchemaSubType string
+	// PayloadValidator validates the serialized payload before it is sent.
+	PayloadValidator func(payload []byte) error
+	// AuthToken is sent in the Authorization header so the plugin accepts the call.
+	AuthToken string
 }
 
 // CallOptionFn defines a function that sets parameters for the Call method.
@@ -65,6 +73,29 @@ func WithQueryParams(queryParams []KV) CallOptionFn {
 	}
 }
 
+// WithSchemaValidation validates the payload against the JSON schema the plugin declared for
+// the given sub-type of pluginType before sending it. The schema is resolved by the plugin wrapper.
+func WithSchemaValidation(pluginType, subType string) CallOptionFn {
+	return func(opt *CallOptions) {
+		opt.SchemaType = pluginType
+		opt.SchemaSubType = subType
+	}
+}
+
+// WithPayloadValidator sets a function that validates the serialized payload before it is sent.
+func WithPayloadValidator(validator func(payload []byte) error) CallOptionFn {
+	return func(opt *CallOptions) {
+		opt.PayloadValidator = validator
+	}
+}
+
+// WithAuthToken authenticates the call with the token the plugin was configured with.
+func WithAuthToken(token string) CallOptionFn {
+	return func(opt *CallOptions) {
+		opt.AuthToken = token
+	}
+}
+
 // Call will use the plugin's constructed connection client to make a call to the specified
 // endpoint. The result will be marshalled into the provided response if not nil.
 func Call(ctx context.Context, client *http.Client, locationType types.ConnectionType, location, endpoint, method string, opts ...CallOptionFn) (err error) {
@@ -73,6 +104,10 @@ func Call(ctx context.Context, client *http.Client, locationType types.Connectio
 		opt(options)
 	}
 
+	if options.SchemaSubType != "" && options.PayloadValidator == nil {
+		return fmt.Errorf("no schema available to validate the payload for sub-type %q", options.SchemaSubType)
+	}
+
 	var body io.Reader
 	if options.Payload != nil {
 		content, err := json.Marshal(options.Payload)
@@ -80,6 +115,12 @@ func Call(ctx context.Context, client *http.Client, locationType types.Connectio
 			return fmt.Errorf("failed to marshal payload: %w", err)
 		}
 
+		if options.PayloadValidator != nil {
+			if err := options.PayloadValidator(content); err != nil {
+				return err
+			}
+		}
+
 		body = bytes.NewReader(content)
 	}
 
@@ -108,6 +149,9 @@ func Call(ctx context.Context, client *http.Client, locationType types.Connectio
 	}
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
+	if options.AuthToken != "" {
+		request.Header.Set("Authorization", types.AuthScheme+" "+options.AuthToken)
+	}
 
 	resp, err := client.Do(request)
 	if err != nil {
@@ -0,0 +1,108 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// supportedFeatures lists the optional handshake features the manager supports.
+var supportedFeatures = []string{types.FeatureTLS}
+
+// ParseHandshake parses and checks the handshake line written by a plugin. The protocol
+// major version has to match the manager's, the plugin has to support the configured
+// connection type, TCP plugins have to listen on a loopback address and abstract socket plugins
+// in the abstract namespace.
+func ParseHandshake(line string, connType types.ConnectionType) (*types.Handshake, error) {
+	handshake := &types.Handshake{}
+	if err := json.Unmarshal([]byte(line), handshake); err != nil {
+		return nil, fmt.Errorf("plugin did not send a valid handshake, got %q: %w", line, err)
+	}
+
+	if err := checkProtocolVersion(handshake.ProtocolVersion); err != nil {
+		return nil, err
+	}
+
+	if handshake.Location == "" {
+		return nil, fmt.Errorf("plugin handshake contains no location")
+	}
+
+	if !slices.Contains(handshake.Transports, connType) {
+		return nil, fmt.Errorf("plugin does not support connection type %q, supported: %v", connType, handshake.Transports)
+	}
+
+	switch connType {
+	case types.TCP:
+		if err := checkLoopback(handshake.Location); err != nil {
+			return nil, err
+		}
+	case types.AbstractSocket:
+		if !strings.HasPrefix(handshake.Location, "http+unix://@") {
+			return nil, fmt.Errorf("plugin handshake contains an invalid abstract socket location %q", handshake.Location)
+		}
+	}
+
+	return handshake, nil
+}
+
+// checkLoopback makes sure a TCP plugin listens on a loopback address only, so it can't be
+// reached from other machines.
+func checkLoopback(location string) error {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("plugin handshake contains an invalid TCP location %q", location)
+	}
+
+	if ip := net.ParseIP(u.Hostname()); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("plugin listens on %s instead of a loopback address", u.Host)
+	}
+
+	return nil
+}
+
+// checkProtocolVersion verifies that the plugin's protocol version has the same major version as ours.
+func checkProtocolVersion(version string) error {
+	if version == "" {
+		return fmt.Errorf("plugin handshake contains no protocol version")
+	}
+
+	pluginMajor, err := majorVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid plugin protocol version %q: %w", version, err)
+	}
+
+	ourMajor, err := majorVersion(types.ProtocolVersion)
+	if err != nil {
+		return fmt.Errorf("invalid manager protocol version %q: %w", types.ProtocolVersion, err)
+	}
+
+	if pluginMajor != ourMajor {
+		return fmt.Errorf("incompatible plugin protocol version %s, manager speaks %s", version, types.ProtocolVersion)
+	}
+
+	return nil
+}
+
+func majorVersion(version string) (int, error) {
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+
+	return strconv.Atoi(major)
+}
+
+// NegotiateFeatures returns the features declared by the plugin that are supported by the manager as well.
+func NegotiateFeatures(supported, declared []string) []string {
+	var features []string
+	for _, feature := range declared {
+		if slices.Contains(supported, feature) && !slices.Contains(features, feature) {
+			features = append(features, feature)
+		}
+	}
+
+	return features
+}
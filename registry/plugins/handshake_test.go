@@ -0,0 +1,116 @@
+package plugins
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+func TestParseHandshake(t *testing.T) {
+	handshake, err := ParseHandshake(`{"protocolVersion": "1.2.0", "sdkVersion": "0.1.0", "location": "http+unix:///tmp/p.socket", "pid": 42, "transports": ["tcp", "unix"], "features": ["tls"]}`, types.Socket)
+	require.NoError(t, err)
+	require.Equal(t, "http+unix:///tmp/p.socket", handshake.Location)
+	require.Equal(t, 42, handshake.PID)
+	require.Equal(t, []string{"tls"}, handshake.Features)
+
+	_, err = ParseHandshake(`http+unix:///tmp/p.socket`, types.Socket)
+	require.ErrorContains(t, err, "plugin did not send a valid handshake")
+
+	_, err = ParseHandshake(`{"protocolVersion": "2.0.0", "location": "x", "transports": ["unix"]}`, types.Socket)
+	require.ErrorContains(t, err, "incompatible plugin protocol version 2.0.0")
+
+	_, err = ParseHandshake(`{"location": "x", "transports": ["unix"]}`, types.Socket)
+	require.ErrorContains(t, err, "no protocol version")
+
+	_, err = ParseHandshake(`{"protocolVersion": "1.0.0", "transports": ["unix"]}`, types.Socket)
+	require.ErrorContains(t, err, "no location")
+
+	_, err = ParseHandshake(`{"protocolVersion": "1.0.0", "location": "x", "transports": ["unix"]}`, types.TCP)
+	require.ErrorContains(t, err, `does not support connection type "tcp"`)
+
+	handshake, err = ParseHandshake(`{"protocolVersion": "1.0.0", "location": "http://127.0.0.1:8080", "transports": ["tcp"]}`, types.TCP)
+	require.NoError(t, err)
+	require.Equal(t, "http://127.0.0.1:8080", handshake.Location)
+
+	_, err = ParseHandshake(`{"protocolVersion": "1.0.0", "location": "http://192.168.1.2:8080", "transports": ["tcp"]}`, types.TCP)
+	require.ErrorContains(t, err, "instead of a loopback address")
+
+	_, err = ParseHandshake(`{"protocolVersion": "1.0.0", "location": "[::]:8080", "transports": ["tcp"]}`, types.TCP)
+	require.ErrorContains(t, err, "invalid TCP location")
+
+	// Abstract socket plugins have to listen in the abstract namespace.
+	handshake, err = ParseHandshake(`{"protocolVersion": "1.0.0", "location": "http+unix://@p-plugin", "transports": ["unix", "abstract"]}`, types.AbstractSocket)
+	require.NoError(t, err)
+	require.Equal(t, "http+unix://@p-plugin", handshake.Location)
+
+	_, err = ParseHandshake(`{"protocolVersion": "1.0.0", "location": "http+unix:///tmp/p.socket", "transports": ["unix", "abstract"]}`, types.AbstractSocket)
+	require.Error(t, err)
+
+	_, err = ParseHandshake(`{"protocolVersion": "1.0.0", "location": "http+unix://@p-plugin", "transports": ["unix"]}`, types.AbstractSocket)
+	require.ErrorContains(t, err, "does not support connection type")
+}
+
+func TestReadHandshake(t *testing.T) {
+	var noise []string
+	stdout := bufio.NewReader(strings.NewReader("starting up\n\n" + `{"level":"INFO","msg":"listening"}` + "\n" + types.HandshakePrefix + `{"protocolVersion":"1.0.0"}` + "\nafter\n"))
+	line, err := readHandshake(t.Context(), stdout, func(line string) { noise = append(noise, line) })
+	require.NoError(t, err)
+	require.Equal(t, `{"protocolVersion":"1.0.0"}`, line)
+	require.Equal(t, []string{"starting up", `{"level":"INFO","msg":"listening"}`}, noise)
+
+	// The rest of the output is left to the caller.
+	rest, err := io.ReadAll(stdout)
+	require.NoError(t, err)
+	require.Equal(t, "after\n", string(rest))
+
+	// Only prefixed lines are taken for the handshake.
+	_, err = readHandshake(t.Context(), bufio.NewReader(strings.NewReader(`{"protocolVersion":"1.0.0"}`)), func(string) {})
+	require.ErrorContains(t, err, "plugin did not output a handshake")
+
+	// Plugins built with SDKs from before the handshake are refused right away.
+	_, err = readHandshake(t.Context(), bufio.NewReader(strings.NewReader("http+unix:///tmp/p-plugin.socket\n")), func(string) {})
+	require.ErrorContains(t, err, "plugin built with an unsupported SDK")
+	_, err = readHandshake(t.Context(), bufio.NewReader(strings.NewReader("http://127.0.0.1:8080\n")), func(string) {})
+	require.ErrorContains(t, err, "plugin built with an unsupported SDK")
+
+	_, err = readHandshake(t.Context(), bufio.NewReader(strings.NewReader("panic: oops\n")), func(string) {})
+	require.ErrorContains(t, err, "plugin did not output a handshake")
+
+	_, err = readHandshake(t.Context(), bufio.NewReader(strings.NewReader(strings.Repeat("noise\n", maxNoiseLines+1))), func(string) {})
+	require.ErrorContains(t, err, "lines without a handshake")
+
+	// A plugin that keeps its stdout open without writing the handshake runs into the deadline.
+	silent, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	_, err = readHandshake(ctx, bufio.NewReader(silent), func(string) {})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNegotiateFeatures(t *testing.T) {
+	require.Empty(t, NegotiateFeatures(nil, []string{types.FeatureTLS}))
+	require.Equal(t, []string{types.FeatureTLS}, NegotiateFeatures(
+		[]string{types.FeatureTLS},
+		[]string{types.FeatureStreaming, types.FeatureTLS, types.FeatureTLS},
+	))
+}
+
+func TestVerifyCapabilities(t *testing.T) {
+	plugin := &types.Plugin{CapabilitiesHash: "sha256:a"}
+
+	require.NoError(t, verifyCapabilities(plugin, &types.Handshake{CapabilitiesHash: "sha256:a"}))
+	require.NoError(t, verifyCapabilities(plugin, &types.Handshake{}))
+	require.ErrorContains(t, verifyCapabilities(plugin, &types.Handshake{CapabilitiesHash: "sha256:b"}), "different capabilities")
+
+	plugin.Manifest = "my-plugin.plugin.json"
+	require.ErrorContains(t, verifyCapabilities(plugin, &types.Handshake{}), "no capabilities hash")
+	require.ErrorContains(t, verifyCapabilities(plugin, &types.Handshake{CapabilitiesHash: "sha256:b"}), "than its manifest my-plugin.plugin.json declares")
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package plugins
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// PeerCredentials returns the process and user ID of the process that created the other end of
+// the Unix socket connection, as recorded by the kernel (SO_PEERCRED).
+func PeerCredentials(conn net.Conn) (pid int, uid uint32, err error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, 0, fmt.Errorf("not a Unix socket connection: %T", conn)
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		cred    *syscall.Ucred
+		credErr error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return 0, 0, err
+	}
+	if credErr != nil {
+		return 0, 0, fmt.Errorf("failed to read the peer credentials: %w", credErr)
+	}
+
+	return int(cred.Pid), cred.Uid, nil
+}
+
+// verifyPeer makes sure the socket is served by the plugin process started by cmd or one of the
+// processes in its process group, running as the user the plugin was started as. Without a
+// started process, only the user is checked.
+func verifyPeer(conn net.Conn, cmd *exec.Cmd) error {
+	pid, uid, err := PeerCredentials(conn)
+	if err != nil {
+		return err
+	}
+
+	expectedUID := uint32(os.Getuid())
+	if cmd != nil && cmd.SysProcAttr != nil && cmd.SysProcAttr.Credential != nil {
+		expectedUID = cmd.SysProcAttr.Credential.Uid
+	}
+	if uid != expectedUID {
+		return fmt.Errorf("%w: served by user %d instead of %d", ErrUntrustedPeer, uid, expectedUID)
+	}
+
+	if cmd == nil || cmd.Process == nil || pid == cmd.Process.Pid {
+		return nil
+	}
+
+	// Plugins run in their own process group, so their subprocesses are in the group of the plugin.
+	if pgid, err := syscall.Getpgid(pid); err == nil && pgid == cmd.Process.Pid {
+		return nil
+	}
+
+	return fmt.Errorf("%w: served by process %d instead of %d", ErrUntrustedPeer, pid, cmd.Process.Pid)
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package plugins
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyPeer(t *testing.T) {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "peer.socket"))
+	require.NoError(t, err)
+	defer listener.Close()
+
+	conn, err := net.Dial("unix", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	pid, uid, err := PeerCredentials(conn)
+	require.NoError(t, err)
+	require.Equal(t, os.Getpid(), pid)
+	require.Equal(t, uint32(os.Getuid()), uid)
+
+	// Without a started process only the user is checked.
+	require.NoError(t, verifyPeer(conn, nil))
+
+	// The socket is served by this process, not by the plugin process.
+	cmd := exec.Command("sleep", "10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	require.ErrorIs(t, verifyPeer(conn, cmd), ErrUntrustedPeer)
+
+	// The plugin is expected to run as another user.
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid + 1}
+	require.ErrorContains(t, verifyPeer(conn, cmd), "served by user")
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package plugins
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+)
+
+// PeerCredentials is only supported on Linux and returns errors.ErrUnsupported.
+func PeerCredentials(net.Conn) (pid int, uid uint32, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
+
+// verifyPeer accepts every peer. Peer credentials are only checked on Linux.
+func verifyPeer(net.Conn, *exec.Cmd) error {
+	return nil
+}
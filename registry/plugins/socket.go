@@ -0,0 +1,42 @@
+package plugins
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// ErrSocketPathTooLong is returned if the path of a plugin socket doesn't fit into a Unix socket
+// address.
+var ErrSocketPathTooLong = errors.New("plugin socket path is too long")
+
+// SocketName returns the name plugin instances use for their socket. Socket paths are short on
+// some platforms, so the name is a short hash of the plugin ID and instance instead of
+// containing them, and it has the same length for every plugin.
+func SocketName(id, instance string) string {
+	sum := sha256.Sum256([]byte(id + "\x00" + instance))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// SocketPath returns the path of the socket file of a plugin instance in dir. It returns
+// ErrSocketPathTooLong if the path doesn't fit into a Unix socket address.
+func SocketPath(dir, id, instance string) (string, error) {
+	path := filepath.Join(dir, SocketName(id, instance)+".sock")
+	if len(path) > MaxSocketPathLength {
+		return "", fmt.Errorf("%w: %s has %d bytes, but at most %d are supported, use a shorter runtime directory",
+			ErrSocketPathTooLong, path, len(path), MaxSocketPathLength)
+	}
+
+	return path, nil
+}
+
+// CheckSocketDir returns ErrSocketPathTooLong if the paths of plugin sockets in dir don't fit
+// into a Unix socket address.
+func CheckSocketDir(dir string) error {
+	_, err := SocketPath(dir, "", "")
+
+	return err
+}
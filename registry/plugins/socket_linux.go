@@ -0,0 +1,7 @@
+//go:build linux
+
+package plugins
+
+// MaxSocketPathLength is the longest path of a Unix socket. The address holds 108 bytes
+// including the terminating NUL byte.
+const MaxSocketPathLength = 107
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package plugins
+
+// MaxSocketPathLength is the longest path of a Unix socket. The address holds 104 bytes
+// including the terminating NUL byte on macOS and the BSDs, the smallest of the supported
+// platforms.
+const MaxSocketPathLength = 103
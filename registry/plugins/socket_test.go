@@ -0,0 +1,26 @@
+package plugins
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocketPath(t *testing.T) {
+	path, err := SocketPath("/run/user/1000/plugins", strings.Repeat("long-plugin-id-", 10), "instance")
+	require.NoError(t, err)
+	require.Regexp(t, `^/run/user/1000/plugins/[0-9a-f]{16}\.sock$`, path)
+
+	// Instances of the same plugin get their own socket.
+	other, err := SocketPath("/run/user/1000/plugins", strings.Repeat("long-plugin-id-", 10), "other")
+	require.NoError(t, err)
+	require.NotEqual(t, path, other)
+
+	dir := "/" + strings.Repeat("d", MaxSocketPathLength)
+	_, err = SocketPath(dir, "plugin", "")
+	require.ErrorIs(t, err, ErrSocketPathTooLong)
+	require.ErrorContains(t, err, "use a shorter runtime directory")
+	require.ErrorIs(t, CheckSocketDir(dir), ErrSocketPathTooLong)
+	require.NoError(t, CheckSocketDir("/tmp"))
+}
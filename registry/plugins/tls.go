@@ -0,0 +1,155 @@
+package plugins
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// certificateValidity is how long the certificates of a certificate authority are valid.
+// The certificate authority only lives as long as the host, so this only has to outlast it.
+const certificateValidity = 365 * 24 * time.Hour
+
+// CertificateAuthority is an ephemeral certificate authority the manager uses to secure the TCP
+// connections to its plugins with mutual TLS. Its keys are generated locally and never stored.
+type CertificateAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// client is the certificate the manager authenticates itself with.
+	client tls.Certificate
+}
+
+// NewCertificateAuthority generates a new certificate authority and the client certificate of the manager.
+func NewCertificateAuthority() (*CertificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the CA key: %w", err)
+	}
+
+	template, err := certificateTemplate("plugin manager CA")
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the CA certificate: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the CA certificate: %w", err)
+	}
+
+	ca := &CertificateAuthority{cert: cert, key: key}
+
+	clientCert, clientKey, err := ca.issue("plugin manager", x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	ca.client = tls.Certificate{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey, Leaf: clientCert}
+
+	return ca, nil
+}
+
+// IssuePluginCertificate issues a server certificate for the plugin with the given ID. It returns
+// the configuration the plugin serves with and the certificate to pin when connecting to it.
+func (ca *CertificateAuthority) IssuePluginCertificate(id string) (*types.TLSConfig, *x509.Certificate, error) {
+	cert, key, err := ca.issue(id, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal the key of plugin %s: %w", id, err)
+	}
+
+	return &types.TLSConfig{
+		Cert:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		Key:      string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		ClientCA: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})),
+	}, cert, nil
+}
+
+// ClientCertificate returns the certificate the manager authenticates itself with.
+func (ca *CertificateAuthority) ClientCertificate() tls.Certificate {
+	return ca.client
+}
+
+// issue creates a new key and a certificate for it signed by the certificate authority.
+func (ca *CertificateAuthority) issue(name string, usage x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate the key for %s: %w", name, err)
+	}
+
+	template, err := certificateTemplate(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the certificate for %s: %w", name, err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the certificate for %s: %w", name, err)
+	}
+
+	return cert, key, nil
+}
+
+// certificateTemplate returns the template of a certificate with a random serial number.
+func certificateTemplate(name string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a serial number: %w", err)
+	}
+
+	now := time.Now()
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(certificateValidity),
+	}, nil
+}
+
+// pinnedTLSConfig returns the client TLS configuration that authenticates with the client
+// certificate and only accepts the pinned server certificate.
+func pinnedTLSConfig(client tls.Certificate, pinned *x509.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{client},
+		MinVersion:   tls.VersionTLS13,
+		// The server certificate is verified by comparing it to the pinned one instead.
+		InsecureSkipVerify: true, //nolint:gosec // G402: the certificate is pinned in VerifyConnection
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 || !state.PeerCertificates[0].Equal(pinned) {
+				return errors.New("plugin did not present the certificate issued for it")
+			}
+
+			return nil
+		},
+	}
+}
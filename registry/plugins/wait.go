@@ -3,7 +3,11 @@ package plugins
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -13,51 +17,204 @@ import (
 	"github.com/Skarlso/go-plugin-framework/types"
 )
 
-// WaitForPlugin waits for a plugin to start up and become available.
-// It reads the plugin's stdout to get the connection details and then
-// creates an HTTP client to communicate with the plugin.
-func WaitForPlugin(ctx context.Context, plugin *types.Plugin) (*http.Client, string, error) {
-	stdoutPipe, err := plugin.Cmd.StdoutPipe()
+var (
+	// ErrHandshake is returned if a plugin sent no or an invalid handshake.
+	ErrHandshake = errors.New("plugin handshake failed")
+	// ErrNotReady is returned if a plugin didn't pass its health check in time.
+	ErrNotReady = errors.New("plugin failed to become ready")
+	// ErrUntrustedPeer is returned if a plugin socket is served by a process other than the plugin.
+	ErrUntrustedPeer = errors.New("plugin socket is served by an untrusted process")
+)
+
+// Connection holds everything needed to talk to a started plugin.
+type Connection struct {
+	// Client is the HTTP client that is set up to reach the plugin.
+	Client *http.Client
+	// Location is where the plugin can be reached.
+	Location string
+	// Handshake is the handshake the plugin sent when it started.
+	Handshake *types.Handshake
+	// Features holds the optional features both the plugin and the manager support.
+	Features []string
+	// Output is what the plugin writes to stdout after the handshake.
+	Output io.Reader
+}
+
+// maxNoiseLines is the number of lines a plugin may write to stdout before its handshake.
+const maxNoiseLines = 1000
+
+// WaitOptions configures WaitForPlugin.
+type WaitOptions struct {
+	// Noise receives the lines the plugin writes to stdout before its handshake.
+	Noise func(line string)
+	// TLS is the client configuration a TCP plugin that serves mutual TLS is reached with.
+	TLS *tls.Config
+}
+
+// WaitOptionFn is a function that configures WaitOptions.
+type WaitOptionFn func(*WaitOptions)
+
+// WithNoise passes the lines the plugin writes to stdout before its handshake to fn.
+// Without it they are dropped.
+func WithNoise(fn func(line string)) WaitOptionFn {
+	return func(o *WaitOptions) {
+		o.Noise = fn
+	}
+}
+
+// WithTLS requires a TCP plugin to serve mutual TLS with the pinned certificate and
+// authenticates to it with the client certificate, see CertificateAuthority.
+func WithTLS(client tls.Certificate, pinned *x509.Certificate) WaitOptionFn {
+	return func(o *WaitOptions) {
+		o.TLS = pinnedTLSConfig(client, pinned)
+	}
+}
+
+// WaitForPlugin waits until ctx is done for a started plugin to become available.
+// It reads the plugin's handshake from stdout, the plugin's standard output, to get the
+// connection details, checks that the plugin is compatible and then creates an HTTP client
+// and waits for the plugin to pass its health check. Lines before the handshake are skipped.
+// The rest of stdout is returned as the connection's Output and has to be consumed.
+func WaitForPlugin(ctx context.Context, plugin *types.Plugin, stdout io.Reader, opts ...WaitOptionFn) (*Connection, error) {
+	options := &WaitOptions{Noise: func(string) {}}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	reader := bufio.NewReader(stdout)
+	line, err := readHandshake(ctx, reader, options.Noise)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get plugin stdout pipe: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrHandshake, err)
 	}
-	scanner := bufio.NewScanner(stdoutPipe)
 
-	// Read the first line which should contain the connection location
-	if !scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, "", fmt.Errorf("failed to read plugin location: %w", err)
-		}
-		return nil, "", fmt.Errorf("plugin did not output connection location")
+	handshake, err := ParseHandshake(line, plugin.Config.Type)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrHandshake, err)
 	}
 
-	location := strings.TrimSpace(scanner.Text())
-	if location == "" {
-		return nil, "", fmt.Errorf("plugin output empty location")
+	if err := verifyCapabilities(plugin, handshake); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrHandshake, err)
 	}
 
-	client, err := createHTTPClient(plugin.Config.Type, location)
+	if options.TLS != nil && plugin.Config.Type == types.TCP && !strings.HasPrefix(handshake.Location, "https://") {
+		return nil, fmt.Errorf("%w: plugin does not serve TLS at %s", ErrHandshake, handshake.Location)
+	}
+
+	client, err := createHTTPClient(plugin, handshake.Location, options.TLS)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create HTTP client: %w", err)
+		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
 	}
 
 	// Wait for the plugin to be ready
-	if err := waitForPluginReady(ctx, client, plugin.Config.Type, location); err != nil {
-		return nil, "", fmt.Errorf("plugin failed to become ready: %w", err)
+	if err := waitForPluginReady(ctx, client, plugin.Config, handshake.Location); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotReady, err)
+	}
+
+	return &Connection{
+		Client:    client,
+		Location:  handshake.Location,
+		Handshake: handshake,
+		Features:  NegotiateFeatures(supportedFeatures, handshake.Features),
+		Output:    reader,
+	}, nil
+}
+
+// readHandshake reads stdout until it finds the handshake line and returns it without the
+// prefix. Lines before it are passed to noise. If ctx is done first, the reading continues in
+// the background until stdout is closed.
+func readHandshake(ctx context.Context, stdout *bufio.Reader, noise func(string)) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+
+	found := make(chan result, 1)
+	go func() {
+		for i := 0; ; i++ {
+			line, err := stdout.ReadString('\n')
+			line = strings.TrimSpace(line)
+			if handshake, ok := strings.CutPrefix(line, types.HandshakePrefix); ok {
+				found <- result{line: handshake}
+				return
+			}
+
+			// Plugins built with SDKs from before the handshake only write their location.
+			if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "http+unix://") {
+				found <- result{err: fmt.Errorf("plugin built with an unsupported SDK: it wrote its location %s instead of a handshake", line)}
+				return
+			}
+
+			switch {
+			case errors.Is(err, io.EOF):
+				found <- result{err: errors.New("plugin did not output a handshake")}
+				return
+			case err != nil:
+				found <- result{err: fmt.Errorf("failed to read plugin handshake: %w", err)}
+				return
+			case i >= maxNoiseLines:
+				found <- result{err: fmt.Errorf("plugin wrote %d lines without a handshake", maxNoiseLines)}
+				return
+			}
+
+			if line != "" {
+				noise(line)
+			}
+		}
+	}()
+
+	select {
+	case r := <-found:
+		return r.line, r.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("plugin did not output a handshake in time: %w", ctx.Err())
+	}
+}
+
+// verifyCapabilities checks that the running plugin serves the capabilities it was registered
+// with. Plugins registered from a manifest must report their capabilities hash because
+// the binary was never asked for its capabilities.
+func verifyCapabilities(plugin *types.Plugin, handshake *types.Handshake) error {
+	if plugin.CapabilitiesHash == "" {
+		return nil
 	}
 
-	return client, location, nil
+	if handshake.CapabilitiesHash == "" {
+		if plugin.Manifest != "" {
+			return fmt.Errorf("plugin reports no capabilities hash to verify its manifest %s against", plugin.Manifest)
+		}
+
+		return nil
+	}
+
+	if plugin.CapabilitiesHash != handshake.CapabilitiesHash {
+		if plugin.Manifest != "" {
+			return fmt.Errorf("plugin serves different capabilities than its manifest %s declares (%s != %s)", plugin.Manifest, handshake.CapabilitiesHash, plugin.CapabilitiesHash)
+		}
+
+		return fmt.Errorf("plugin serves different capabilities than it declared (%s != %s)", handshake.CapabilitiesHash, plugin.CapabilitiesHash)
+	}
+
+	return nil
 }
 
-func createHTTPClient(connType types.ConnectionType, location string) (*http.Client, error) {
-	switch connType {
+// createHTTPClient creates the client for the plugin at location. TCP plugins are reached over
+// TLS if tlsConfig is set. Connections to socket plugins are only used if the socket is served by
+// the plugin process, see verifyPeer.
+func createHTTPClient(plugin *types.Plugin, location string, tlsConfig *tls.Config) (*http.Client, error) {
+	switch connType := plugin.Config.Type; connType {
 	case types.TCP:
-		// For TCP, location is already a host:port
+		// For TCP, location is already an http or https URL. The transport keeps the timeouts
+		// of the default transport, but plugins are local and never reached through a proxy.
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.TLSClientConfig = tlsConfig
+
 		return &http.Client{
-			Timeout: 30 * time.Second,
+			Transport: transport,
+			Timeout:   30 * time.Second,
 		}, nil
-	case types.Socket:
-		// For Unix socket, extract the socket path from the URL
+	case types.Socket, types.AbstractSocket:
+		// For Unix socket, extract the socket path from the URL. Abstract socket names start with @.
 		socketURL, err := url.Parse(location)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse socket URL: %w", err)
@@ -72,7 +229,17 @@ func createHTTPClient(connType types.ConnectionType, location string) (*http.Cli
 		return &http.Client{
 			Transport: &http.Transport{
 				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+					conn, err := (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+					if err != nil {
+						return nil, err
+					}
+
+					if err := verifyPeer(conn, plugin.Cmd); err != nil {
+						_ = conn.Close()
+						return nil, err
+					}
+
+					return conn, nil
 				},
 			},
 			Timeout: 30 * time.Second,
@@ -82,23 +249,29 @@ func createHTTPClient(connType types.ConnectionType, location string) (*http.Cli
 	}
 }
 
-func waitForPluginReady(ctx context.Context, client *http.Client, connType types.ConnectionType, location string) error {
+// waitForPluginReady polls the health endpoint of the plugin until it answers or ctx is done.
+func waitForPluginReady(ctx context.Context, client *http.Client, config types.Config, location string) error {
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 
-	timeout := time.After(30 * time.Second)
-
+	var lastErr error
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
-		case <-timeout:
-			return fmt.Errorf("timeout waiting for plugin to become ready")
+			if lastErr != nil {
+				return fmt.Errorf("timeout waiting for plugin to become ready: %w, last health check: %w", ctx.Err(), lastErr)
+			}
+
+			return fmt.Errorf("timeout waiting for plugin to become ready: %w", ctx.Err())
 		case <-ticker.C:
-			if err := Call(ctx, client, connType, location, "/healthz", http.MethodGet); err == nil {
+			err := Call(ctx, client, config.Type, location, "/healthz", http.MethodGet, WithAuthToken(config.AuthToken))
+			if err == nil {
 				return nil
 			}
-			// Continue trying if health check fails
+			// Continue trying if health check fails, remembering why unless it was cut off by ctx
+			if ctx.Err() == nil {
+				lastErr = err
+			}
 		}
 	}
 }
@@ -0,0 +1,46 @@
+//go:build linux
+
+package registry
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessAttributes starts the plugin in its own process group, so the group can be signalled
+// as a whole, and makes the kernel kill the plugin if the host dies. Pdeathsig is bound to the
+// thread that started the process, not to the host (golang/go#27505), so the plugin is also
+// killed if that thread exits while the host keeps running. The Go runtime only exits threads
+// of goroutines that called runtime.LockOSThread and returned without unlocking; a plugin
+// killed that way is restarted according to its restart policy.
+func setProcessAttributes(cmd *exec.Cmd, credential *Credential) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	if credential != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid: credential.UID,
+			Gid: credential.GID,
+		}
+	}
+
+	return nil
+}
+
+// signalGroup sends sig to the process group of the plugin, which includes every subprocess
+// the plugin started and didn't move to another group.
+func signalGroup(process *os.Process, sig syscall.Signal) error {
+	if err := syscall.Kill(-process.Pid, sig); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return os.ErrProcessDone
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+//go:build linux
+
+package registry
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+func TestStopKillsProcessGroup(t *testing.T) {
+	registry := NewRegistry(context.Background())
+
+	// The plugin starts a subprocess that ignores SIGTERM and would outlive it.
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	require.NoError(t, registry.AddExternalPlugin(testPlugin(t, "group-plugin", testPluginIgnoreShutdown,
+		`sh -c "trap '' TERM; while :; do sleep 0.1; done" & echo $! > `+pidFile)))
+	ext, err := registry.external("group-plugin")
+	require.NoError(t, err)
+
+	var child int
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		child, err = strconv.Atoi(strings.TrimSpace(string(content)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	result := registry.stop(context.Background(), ext)
+	require.NoError(t, result.Err)
+	require.Equal(t, StepTerminate, result.Step)
+
+	// The subprocess was killed with the plugin's process group.
+	require.Eventually(t, func() bool {
+		return syscall.Kill(child, 0) == syscall.ESRCH
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestProcessLimits(t *testing.T) {
+	plugin := &types.Plugin{
+		ID:        "limited-plugin",
+		Config:    types.Config{ID: "limited-plugin"},
+		Resources: &types.ResourceLimits{AddressSpaceBytes: 1 << 30, OpenFiles: 64, CPUSeconds: 1},
+	}
+
+	limits, err := newProcessLimits(plugin, "")
+	require.NoError(t, err)
+
+	// The plugin has its limits from the start and spins until it used up its CPU time.
+	var output strings.Builder
+	cmd := exec.Command("/bin/sh", "-c", `cat /proc/$$/limits; while :; do :; done`)
+	cmd.Stdout = &output
+	require.NoError(t, setProcessAttributes(cmd, nil))
+	limits.prepare(cmd)
+	require.NoError(t, cmd.Start())
+
+	exitErr := limits.exitReason(cmd, cmd.Wait())
+	require.ErrorIs(t, exitErr, ErrLimitExceeded)
+	require.ErrorContains(t, exitErr, "CPU time limit of 1 seconds")
+	limits.release()
+
+	require.Regexp(t, `Max address space\s+1073741824\s+1073741824`, output.String())
+	require.Regexp(t, `Max open files\s+64\s+64`, output.String())
+	require.Regexp(t, `Max cpu time\s+1\s+2`, output.String())
+
+	// Plugins are started through the shim with their arguments intact.
+	registry := NewRegistry(t.Context())
+	limited := testPlugin(t, "limited-plugin", testPluginServe, "")
+	limited.Resources = &types.ResourceLimits{OpenFiles: 128}
+	require.NoError(t, registry.AddExternalPlugin(limited))
+	ext, err := registry.external("limited-plugin")
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(ext.wrapper.cmd().Process.Pid), "limits"))
+	require.NoError(t, err)
+	require.Regexp(t, `Max open files\s+128\s+128`, string(content))
+	require.NoError(t, registry.stop(t.Context(), ext).Err)
+}
+
+func TestStoppedPluginClosesOutput(t *testing.T) {
+	openFiles := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	registry := NewRegistry(t.Context())
+	before := openFiles()
+
+	require.NoError(t, registry.AddExternalPlugin(testPlugin(t, "output-plugin", testPluginServe, "")))
+	ext, err := registry.external("output-plugin")
+	require.NoError(t, err)
+	require.NoError(t, registry.stop(t.Context(), ext).Err)
+
+	// The read end of the stdout pipe is closed once the output was forwarded.
+	require.Eventually(t, func() bool {
+		return openFiles() <= before
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package registry
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessAttributes leaves the plugin in the process group of the host. Process groups and
+// credentials are only set up on Linux.
+func setProcessAttributes(_ *exec.Cmd, credential *Credential) error {
+	if credential != nil {
+		return errors.New("running plugins as a different user is only supported on Linux")
+	}
+
+	return nil
+}
+
+// signalGroup sends sig to the plugin process only.
+func signalGroup(process *os.Process, sig syscall.Signal) error {
+	return process.Signal(sig)
+}
@@ -1,18 +1,30 @@
 package registry
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
-	"net/http"
-	"os"
+	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Skarlso/go-plugin-framework/contracts"
+	"github.com/Skarlso/go-plugin-framework/internal/concurrency"
 	"github.com/Skarlso/go-plugin-framework/registry/plugins"
 	"github.com/Skarlso/go-plugin-framework/types"
 )
 
+var (
+	// ErrAlreadyRegistered is returned if a plugin ID or type is already taken.
+	ErrAlreadyRegistered = errors.New("already registered")
+	// ErrInvalidSchema is returned if a plugin declares a JSON schema that doesn't compile.
+	ErrInvalidSchema = errors.New("invalid JSON schema")
+)
+
 // Registry manages both internal and external plugins.
 type Registry struct {
 	ctx context.Context
@@ -21,14 +33,158 @@ type Registry struct {
 	// internalPlugins holds plugins that are compiled into the application
 	internalPlugins map[string]contracts.PluginBase
 
-	// externalPlugins holds external plugin processes
-	externalPlugins map[string]*ExternalPlugin
+	// externalPlugins holds external plugin processes per type in registration order
+	externalPlugins map[string][]*ExternalPlugin
+
+	// pluginsByID holds every external plugin by its ID
+	pluginsByID map[string]*ExternalPlugin
+
+	// selectors holds the default selector configured per type
+	selectors map[string]Selector
+
+	// instances counts the replacement processes started, to give each a unique instance name
+	instances atomic.Uint64
+
+	eventsMu sync.RWMutex
+	// handlers are called for every lifecycle event
+	handlers []EventHandler
 }
 
 // ExternalPlugin represents a running external plugin.
 type ExternalPlugin struct {
 	Plugin types.Plugin
 	Client contracts.PluginBase
+
+	wrapper      *ExternalPluginWrapper
+	policy       RestartPolicy
+	credential   *Credential
+	cgroupParent string
+	// limits holds the resource limits of the current plugin process. It's only used by whoever
+	// starts the process and its supervisor, which never run at the same time.
+	limits *processLimits
+	// output routes the output of the plugin processes and keeps the most recent lines.
+	output *pluginOutput
+	// startupTimeout bounds the wait for a new plugin process to become ready.
+	startupTimeout time.Duration
+	// ca issues the certificates of TCP plugin processes that serve mutual TLS.
+	ca *plugins.CertificateAuthority
+	// startMu serializes on-demand starts of the plugin process.
+	startMu sync.Mutex
+	// stopping is set once the plugin is being shut down so the supervisor doesn't restart it.
+	stopping atomic.Bool
+	// retired is set once the plugin was unregistered, replaced or shut down. Unlike stopping, it's
+	// never cleared, so the plugin isn't started again.
+	retired atomic.Bool
+	// done is closed when the supervisor of the current plugin process returns.
+	done chan struct{}
+}
+
+// ExternalPluginOptions holds configuration for a single external plugin.
+type ExternalPluginOptions struct {
+	RestartPolicy RestartPolicy
+	// LazyStart registers the plugin without starting it. The process is started on first use.
+	LazyStart bool
+	// Credential runs the plugin process as a different user and group. It's only supported on Linux.
+	Credential *Credential
+	// CgroupParent is a cgroup v2 directory the plugin processes get their own cgroup in, to apply
+	// the CPU, memory and process limits of the plugin to all of its processes. It's only supported on Linux.
+	CgroupParent string
+	// OutputLines is the number of recent output lines of the plugin kept for PluginOutput. Defaults to 200.
+	OutputLines int
+	// OutputLogger logs the output of the plugin processes. Defaults to the default slog logger.
+	OutputLogger *slog.Logger
+	// StartupTimeout bounds the wait for a new plugin process to output its handshake and pass its
+	// health check. Defaults to 30 seconds.
+	StartupTimeout time.Duration
+	// TLS secures the connections to a TCP plugin with mutual TLS using certificates issued by the
+	// certificate authority. It's ignored for other connection types.
+	TLS *plugins.CertificateAuthority
+}
+
+// Credential is the user and group a plugin process runs as.
+type Credential struct {
+	UID uint32
+	GID uint32
+}
+
+// ExternalPluginOptionFn is a function that configures ExternalPluginOptions.
+type ExternalPluginOptionFn func(*ExternalPluginOptions)
+
+// WithRestartPolicy configures if and how the plugin is restarted after its process exits.
+func WithRestartPolicy(policy RestartPolicy) ExternalPluginOptionFn {
+	return func(o *ExternalPluginOptions) {
+		o.RestartPolicy = policy
+	}
+}
+
+// WithLazyStart registers the plugin without starting its process. The process is started
+// by the first GetPlugin or call to the plugin.
+func WithLazyStart() ExternalPluginOptionFn {
+	return func(o *ExternalPluginOptions) {
+		o.LazyStart = true
+	}
+}
+
+// WithCredential runs the plugin process as the given user and group. The host needs the
+// privileges to switch to them. It's only supported on Linux.
+func WithCredential(uid, gid uint32) ExternalPluginOptionFn {
+	return func(o *ExternalPluginOptions) {
+		o.Credential = &Credential{UID: uid, GID: gid}
+	}
+}
+
+// WithCgroupParent creates a cgroup for every process of the plugin below the given cgroup v2
+// directory, which has to be writable by the host. The plugin's resource limits then apply to
+// all of its processes. If the cgroup can't be created, only the rlimits are applied.
+func WithCgroupParent(dir string) ExternalPluginOptionFn {
+	return func(o *ExternalPluginOptions) {
+		o.CgroupParent = dir
+	}
+}
+
+// WithOutputLines keeps the n most recent lines the plugin wrote to stdout and stderr for PluginOutput.
+func WithOutputLines(n int) ExternalPluginOptionFn {
+	return func(o *ExternalPluginOptions) {
+		o.OutputLines = n
+	}
+}
+
+// WithOutputLogger logs what the plugin processes write to stdout and stderr with logger instead
+// of the default slog logger.
+func WithOutputLogger(logger *slog.Logger) ExternalPluginOptionFn {
+	return func(o *ExternalPluginOptions) {
+		o.OutputLogger = logger
+	}
+}
+
+// WithStartupTimeout bounds the wait for a new plugin process to output its handshake and pass its
+// health check. A process that isn't ready in time is killed.
+func WithStartupTimeout(d time.Duration) ExternalPluginOptionFn {
+	return func(o *ExternalPluginOptions) {
+		o.StartupTimeout = d
+	}
+}
+
+// WithTLS secures the connections to a TCP plugin with mutual TLS. Every plugin process is
+// issued a new server certificate by ca, which is pinned when connecting to it, and the
+// registry authenticates with the client certificate of ca.
+func WithTLS(ca *plugins.CertificateAuthority) ExternalPluginOptionFn {
+	return func(o *ExternalPluginOptions) {
+		o.TLS = ca
+	}
+}
+
+// newExternalPluginOptions applies opts to the default options.
+func newExternalPluginOptions(opts []ExternalPluginOptionFn) *ExternalPluginOptions {
+	options := &ExternalPluginOptions{
+		RestartPolicy:  RestartPolicy{Mode: RestartNever},
+		StartupTimeout: defaultStartupTimeout,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options
 }
 
 // NewRegistry creates a new plugin registry.
@@ -36,145 +192,315 @@ func NewRegistry(ctx context.Context) *Registry {
 	return &Registry{
 		ctx:             ctx,
 		internalPlugins: make(map[string]contracts.PluginBase),
-		externalPlugins: make(map[string]*ExternalPlugin),
+		externalPlugins: make(map[string][]*ExternalPlugin),
+		pluginsByID:     make(map[string]*ExternalPlugin),
+		selectors:       make(map[string]Selector),
 	}
 }
 
+// GetOptions holds configuration for looking up a plugin.
+type GetOptions struct {
+	// Selector picks one of the external plugins registered for the type.
+	Selector Selector
+}
+
+// GetOptionFn is a function that configures GetOptions.
+type GetOptionFn func(*GetOptions)
+
+// WithSelector sets the selector used to pick between several external plugins of the same type.
+func WithSelector(selector Selector) GetOptionFn {
+	return func(o *GetOptions) {
+		o.Selector = selector
+	}
+}
+
+// SetSelector sets the default selector used for lookups of the given type that don't pass
+// their own selector. Without a default, the plugin registered first is used.
+func (r *Registry) SetSelector(pluginType string, selector Selector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.selectors[pluginType] = selector
+}
+
 // RegisterInternal registers an internal plugin implementation.
 func (r *Registry) RegisterInternal(pluginType string, plugin contracts.PluginBase) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if _, exists := r.internalPlugins[pluginType]; exists {
-		return fmt.Errorf("internal plugin for type %q already registered", pluginType)
+		return fmt.Errorf("internal plugin for type %q %w", pluginType, ErrAlreadyRegistered)
 	}
 
 	r.internalPlugins[pluginType] = plugin
 	return nil
 }
 
-// AddExternalPlugin starts and registers an external plugin.
-func (r *Registry) AddExternalPlugin(plugin types.Plugin) error {
+// AddExternalPlugin starts and registers an external plugin. The plugin process is supervised
+// for its whole lifetime and restarted according to the configured restart policy. Plugins
+// added with WithLazyStart are only registered and started once they are first used.
+func (r *Registry) AddExternalPlugin(plugin types.Plugin, opts ...ExternalPluginOptionFn) error {
+	return r.AddExternalPlugins(r.ctx, []types.Plugin{plugin}, 1, opts...)[0]
+}
+
+// AddExternalPlugins registers several external plugins in the given order and then starts up
+// to limit of them at the same time. Conflicts are resolved by that order, so the first
+// plugin wins an ID and is the first registered provider of its types. ctx bounds the wait for
+// the plugins to become ready. A plugin that fails to start is removed again. The returned
+// slice holds the error of every plugin at the plugin's index.
+func (r *Registry) AddExternalPlugins(ctx context.Context, plugins []types.Plugin, limit int, opts ...ExternalPluginOptionFn) []error {
+	options := newExternalPluginOptions(opts)
+
+	errs := make([]error, len(plugins))
+	added := make([]*ExternalPlugin, len(plugins))
+
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	for i, plugin := range plugins {
+		added[i], errs[i] = r.addLocked(plugin, options)
+	}
+	r.mu.Unlock()
 
-	// Check if we already have a plugin for any of the types this plugin supports
-	for pluginType := range plugin.Types {
-		if _, exists := r.internalPlugins[pluginType]; exists {
-			return fmt.Errorf("internal plugin for type %q already registered", pluginType)
+	for _, externalPlugin := range added {
+		if externalPlugin != nil {
+			r.emit(EventRegistered, externalPlugin.Plugin.ID, nil)
 		}
-		if _, exists := r.externalPlugins[pluginType]; exists {
-			return fmt.Errorf("external plugin for type %q already registered", pluginType)
+	}
+
+	if options.LazyStart {
+		return errs
+	}
+
+	// Start the plugins and wait for them to be ready
+	concurrency.ForEach(len(added), limit, func(i int) {
+		if added[i] != nil {
+			errs[i] = r.startInitial(ctx, added[i])
 		}
+	})
+
+	return errs
+}
+
+// addLocked registers an external plugin without starting it. The caller has to hold r.mu.
+func (r *Registry) addLocked(plugin types.Plugin, options *ExternalPluginOptions) (*ExternalPlugin, error) {
+	if _, exists := r.pluginsByID[plugin.ID]; exists {
+		return nil, fmt.Errorf("external plugin with id %q %w", plugin.ID, ErrAlreadyRegistered)
 	}
 
-	// Start the plugin
-	if err := plugin.Cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start plugin %s: %w", plugin.ID, err)
+	externalPlugin, err := r.newExternalPlugin(plugin, options)
+	if err != nil {
+		return nil, err
 	}
 
-	// Wait for the plugin to be ready
-	client, location, err := plugins.WaitForPlugin(r.ctx, &plugin)
+	// Register for all types this plugin supports
+	r.pluginsByID[plugin.ID] = externalPlugin
+	for pluginType := range plugin.Types {
+		r.externalPlugins[pluginType] = append(r.externalPlugins[pluginType], externalPlugin)
+	}
+
+	return externalPlugin, nil
+}
+
+// newExternalPlugin sets up an external plugin without registering it. The caller has to hold r.mu.
+func (r *Registry) newExternalPlugin(plugin types.Plugin, options *ExternalPluginOptions) (*ExternalPlugin, error) {
+	// Internal plugins always take precedence, so an external plugin can't share their types
+	for pluginType := range plugin.Types {
+		if _, exists := r.internalPlugins[pluginType]; exists {
+			return nil, fmt.Errorf("internal plugin for type %q %w", pluginType, ErrAlreadyRegistered)
+		}
+	}
+
+	schemas, err := compileSchemas(plugin)
 	if err != nil {
-		return fmt.Errorf("failed to wait for plugin %s to start: %w", plugin.ID, err)
+		return nil, err
 	}
 
-	// Create a wrapper that implements the PluginBase interface
-	pluginWrapper := &ExternalPluginWrapper{
-		client:         client,
-		location:       location,
-		connectionType: plugin.Config.Type,
-		plugin:         &plugin,
+	// Every plugin gets its own secret, so nothing but the registry can call it.
+	if plugin.Config.AuthToken == "" {
+		plugin.Config.AuthToken = rand.Text()
 	}
 
 	externalPlugin := &ExternalPlugin{
-		Plugin: plugin,
-		Client: pluginWrapper,
+		Plugin:       plugin,
+		policy:       options.RestartPolicy,
+		credential:   options.Credential,
+		cgroupParent: options.CgroupParent,
+		output:       newPluginOutput(plugin.ID, options.OutputLines, options.OutputLogger),
+	}
+	externalPlugin.ca = options.TLS
+	externalPlugin.startupTimeout = options.StartupTimeout
+	if externalPlugin.startupTimeout <= 0 {
+		externalPlugin.startupTimeout = defaultStartupTimeout
 	}
 
-	// Register for all types this plugin supports
-	for pluginType := range plugin.Types {
-		r.externalPlugins[pluginType] = externalPlugin
+	// Create a wrapper that implements the PluginBase interface
+	externalPlugin.wrapper = &ExternalPluginWrapper{
+		connectionType: plugin.Config.Type,
+		authToken:      plugin.Config.AuthToken,
+		plugin:         &externalPlugin.Plugin,
+		schemas:        schemas,
+		starter: func(ctx context.Context) error {
+			return r.ensureStarted(ctx, externalPlugin)
+		},
 	}
+	externalPlugin.Client = externalPlugin.wrapper
 
-	return nil
+	return externalPlugin, nil
 }
 
-// GetPlugin returns a plugin for the specified type.
-func (r *Registry) GetPlugin(ctx context.Context, pluginType string) (contracts.PluginBase, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// remove removes an external plugin from all its type mappings.
+func (r *Registry) remove(ext *ExternalPlugin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeLocked(ext)
+}
+
+// removeLocked removes an external plugin from all its type mappings. The caller has to hold r.mu.
+func (r *Registry) removeLocked(ext *ExternalPlugin) {
+	if r.pluginsByID[ext.Plugin.ID] == ext {
+		delete(r.pluginsByID, ext.Plugin.ID)
+	}
 
+	for pluginType := range ext.Plugin.Types {
+		r.externalPlugins[pluginType] = slices.DeleteFunc(r.externalPlugins[pluginType], func(p *ExternalPlugin) bool {
+			return p == ext
+		})
+		if len(r.externalPlugins[pluginType]) == 0 {
+			delete(r.externalPlugins, pluginType)
+		}
+	}
+}
+
+// GetPlugin returns a plugin for the specified type. If several external plugins provide
+// the type, the selector passed with WithSelector, the default selector of the type or
+// FirstRegistered picks one, in that order. External plugins that aren't running because
+// they were registered lazily or stopped after being idle are started.
+func (r *Registry) GetPlugin(ctx context.Context, pluginType string, opts ...GetOptionFn) (contracts.PluginBase, error) {
+	options := &GetOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	r.mu.RLock()
 	// Check internal plugins first
 	if plugin, exists := r.internalPlugins[pluginType]; exists {
+		r.mu.RUnlock()
 		return plugin, nil
 	}
 
 	// Check external plugins
-	if externalPlugin, exists := r.externalPlugins[pluginType]; exists {
-		return externalPlugin.Client, nil
+	candidates := slices.Clone(r.externalPlugins[pluginType])
+	selector := options.Selector
+	if selector == nil {
+		selector = r.selectors[pluginType]
 	}
+	r.mu.RUnlock()
 
-	return nil, fmt.Errorf("no plugin found for type %q", pluginType)
-}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no plugin found for type %q", pluginType)
+	}
 
-// Shutdown stops all external plugins.
-func (r *Registry) Shutdown(ctx context.Context) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	if selector == nil {
+		selector = FirstRegistered()
+	}
 
-	var errs []error
+	externalPlugin, err := selector(pluginType, candidates)
+	if err != nil {
+		return nil, err
+	}
 
-	// Shutdown all external plugins
-	processedPlugins := make(map[string]bool)
-	for _, externalPlugin := range r.externalPlugins {
-		if processedPlugins[externalPlugin.Plugin.ID] {
-			continue // Already processed this plugin
-		}
-		processedPlugins[externalPlugin.Plugin.ID] = true
+	if err := r.ensureStarted(ctx, externalPlugin); err != nil {
+		return nil, err
+	}
 
-		// Send interrupt signal to the plugin
-		if err := externalPlugin.Plugin.Cmd.Process.Signal(os.Interrupt); err != nil {
-			errs = append(errs, fmt.Errorf("failed to send interrupt to plugin %s: %w", externalPlugin.Plugin.ID, err))
-		}
+	return externalPlugin.Client, nil
+}
+
+// GetPluginFor returns the external plugin that declared subType in its TypeInfo list for
+// pluginType. If several plugins declare the same sub-type, the one registered first is used.
+// Internal plugins don't declare sub-types and are never returned.
+func (r *Registry) GetPluginFor(ctx context.Context, pluginType, subType string) (contracts.PluginBase, error) {
+	r.mu.RLock()
+	candidates := slices.Clone(r.externalPlugins[pluginType])
+	r.mu.RUnlock()
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no plugin found for type %q with sub-type %q", pluginType, subType)
 	}
 
-	if len(errs) > 0 {
-		return errors.Join(errs...)
+	externalPlugin, err := BySubType(subType)(pluginType, candidates)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
-}
+	if err := r.ensureStarted(ctx, externalPlugin); err != nil {
+		return nil, err
+	}
 
-// ExternalPluginWrapper wraps an external plugin to implement the PluginBase interface.
-type ExternalPluginWrapper struct {
-	client         *http.Client
-	location       string
-	connectionType types.ConnectionType
-	plugin         *types.Plugin
+	return externalPlugin.Client, nil
 }
 
-// Ping implements the PluginBase interface.
-func (w *ExternalPluginWrapper) Ping(ctx context.Context) error {
-	return plugins.Call(ctx, w.client, w.connectionType, w.location, "/healthz", "GET")
+// TypeEntry describes a single type a plugin provides.
+type TypeEntry struct {
+	// Type is the plugin type, for example dataProcessor.
+	Type string
+	// SubType is the TypeInfo.Type declared by the plugin. It is empty for internal plugins.
+	SubType string
+	// PluginID is the ID of the external plugin. It is empty for internal plugins.
+	PluginID string
+	// Internal is set for plugins compiled into the application.
+	Internal bool
 }
 
-// GetHTTPClient returns the HTTP client for making calls to the plugin.
-func (w *ExternalPluginWrapper) GetHTTPClient() *http.Client {
-	return w.client
-}
+// ListTypes returns every type, sub-type and plugin combination known to the registry,
+// sorted by type, sub-type and plugin ID.
+func (r *Registry) ListTypes() []TypeEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-// GetLocation returns the plugin's connection location.
-func (w *ExternalPluginWrapper) GetLocation() string {
-	return w.location
-}
+	var entries []TypeEntry
+	for pluginType := range r.internalPlugins {
+		entries = append(entries, TypeEntry{Type: pluginType, Internal: true})
+	}
+
+	for pluginType, externalPlugins := range r.externalPlugins {
+		for _, externalPlugin := range externalPlugins {
+			for _, info := range externalPlugin.Plugin.Types[pluginType] {
+				entries = append(entries, TypeEntry{
+					Type:     pluginType,
+					SubType:  info.Type,
+					PluginID: externalPlugin.Plugin.ID,
+				})
+			}
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b TypeEntry) int {
+		return cmp.Or(
+			cmp.Compare(a.Type, b.Type),
+			cmp.Compare(a.SubType, b.SubType),
+			cmp.Compare(a.PluginID, b.PluginID),
+		)
+	})
 
-// GetConnectionType returns the plugin's connection type.
-func (w *ExternalPluginWrapper) GetConnectionType() types.ConnectionType {
-	return w.connectionType
+	return entries
 }
 
-// CallPlugin makes an HTTP call to the plugin.
-func (w *ExternalPluginWrapper) CallPlugin(ctx context.Context, endpoint, method string, opts ...plugins.CallOptionFn) error {
-	return plugins.Call(ctx, w.client, w.connectionType, w.location, endpoint, method, opts...)
+// GetPlugins returns every plugin registered for the specified type. An internal plugin
+// comes first, followed by the external plugins in registration order. External plugins
+// are not started by listing them; they are started on their first call.
+func (r *Registry) GetPlugins(_ context.Context, pluginType string) []contracts.PluginBase {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []contracts.PluginBase
+	if plugin, exists := r.internalPlugins[pluginType]; exists {
+		result = append(result, plugin)
+	}
+
+	for _, externalPlugin := range r.externalPlugins[pluginType] {
+		result = append(result, externalPlugin.Client)
+	}
+
+	return result
 }
@@ -2,9 +2,30 @@ package registry
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/contracts"
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+	"github.com/Skarlso/go-plugin-framework/types"
 )
 
 // MockPlugin implements PluginBase for testing
@@ -56,6 +77,752 @@ func TestRegistryShutdown(t *testing.T) {
 	require.NoError(t, err)
 
 	// Shutdown should not error even with internal plugins
-	err = registry.Shutdown(ctx)
+	results, err := registry.Shutdown(ctx)
+	require.NoError(t, err)
+	require.Empty(t, results)
+}
+
+func TestRestartPolicy(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	require.False(t, RestartPolicy{Mode: RestartNever}.shouldRestart(exitErr))
+	require.False(t, RestartPolicy{}.shouldRestart(exitErr))
+	require.True(t, RestartPolicy{Mode: RestartOnFailure}.shouldRestart(exitErr))
+	require.False(t, RestartPolicy{Mode: RestartOnFailure}.shouldRestart(nil))
+	require.True(t, RestartPolicy{Mode: RestartAlways}.shouldRestart(nil))
+
+	policy := RestartPolicy{
+		Mode:           RestartAlways,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+	require.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	require.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	require.Equal(t, 800*time.Millisecond, policy.backoff(4))
+	require.Equal(t, time.Second, policy.backoff(5))
+	require.Equal(t, time.Second, policy.backoff(50))
+}
+
+func TestExternalPluginWrapperExited(t *testing.T) {
+	wrapper := &ExternalPluginWrapper{
+		connectionType: types.TCP,
+		plugin:         &types.Plugin{ID: "test-plugin"},
+	}
+
+	wrapper.markExited(stateExited, errors.New("signal: killed"))
+	err := wrapper.Ping(context.Background())
+	require.ErrorIs(t, err, ErrPluginExited)
+
+	wrapper.swap(nil, &plugins.Connection{Client: &http.Client{}, Location: "http://127.0.0.1:1"})
+	require.Equal(t, "http://127.0.0.1:1", wrapper.GetLocation())
+	require.NotErrorIs(t, wrapper.Ping(context.Background()), ErrPluginExited)
+}
+
+func TestRegistryLazyStart(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(ctx)
+
+	plugin := types.Plugin{
+		ID:     "lazy-plugin",
+		Path:   "/non-existent/lazy-plugin",
+		Config: types.Config{ID: "lazy-plugin", Type: types.TCP},
+		Types: map[string][]types.TypeInfo{
+			"lazy-type": {{Type: "lazy"}},
+		},
+	}
+
+	// Registering doesn't start the plugin, so the missing binary isn't noticed yet
+	err := registry.AddExternalPlugin(plugin, WithLazyStart())
+	require.NoError(t, err)
+
+	// The first lookup tries to start it
+	_, err = registry.GetPlugin(ctx, "lazy-type")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to start plugin lazy-plugin")
+
+	// Nothing is running so shutdown has nothing to stop
+	results, err := registry.Shutdown(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ShutdownResult{{ID: "lazy-plugin", Step: StepNotRunning, ExitCode: -1}}, results)
+}
+
+func TestIsIdleExit(t *testing.T) {
+	require.False(t, isIdleExit(nil))
+	require.False(t, isIdleExit(errors.New("signal: killed")))
+
+	err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", types.IdleExitCode)).Run()
+	require.True(t, isIdleExit(err))
+
+	err = exec.Command("sh", "-c", "exit 1").Run()
+	require.False(t, isIdleExit(err))
+}
+
+func TestSupervisedPluginProcess(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(ctx)
+
+	var events []EventType
+	var eventsMu sync.Mutex
+	registry.AddEventHandler(func(event Event) {
+		eventsMu.Lock()
+		defer eventsMu.Unlock()
+		events = append(events, event.Type)
+	})
+
+	plugin := testPlugin(t, "supervised-plugin", testPluginServe, "")
+	require.NoError(t, registry.AddExternalPlugin(plugin, WithRestartPolicy(RestartPolicy{
+		Mode:           RestartAlways,
+		InitialBackoff: 10 * time.Millisecond,
+	})))
+
+	processor, err := GetTyped[contracts.DataProcessor](ctx, registry, "dataProcessor")
+	require.NoError(t, err)
+	result, err := processor.ProcessData(ctx, []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "HELLO", string(result))
+
+	// A crashed process is replaced by the supervisor, and the client keeps working.
+	ext, err := registry.external("supervised-plugin")
+	require.NoError(t, err)
+	crashed := ext.wrapper.cmd().Process.Pid
+	require.NoError(t, syscall.Kill(crashed, syscall.SIGKILL))
+	require.Eventually(t, func() bool {
+		state, _ := ext.wrapper.status()
+		return state == stateRunning && ext.wrapper.cmd().Process.Pid != crashed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	result, err = processor.ProcessData(ctx, []byte("again"))
+	require.NoError(t, err)
+	require.Equal(t, "AGAIN", string(result))
+
+	// Lazily registered plugins are started by their first lookup.
+	require.NoError(t, registry.AddExternalPlugin(testPlugin(t, "lazy-plugin", testPluginServe, ""), WithLazyStart()))
+	lazy, err := registry.GetPluginFor(ctx, "dataProcessor", "lazy-plugin")
+	require.NoError(t, err)
+	require.NoError(t, lazy.Ping(ctx))
+
+	results, err := registry.Shutdown(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ShutdownResult{
+		{ID: "lazy-plugin", Step: StepEndpoint, ExitCode: 0},
+		{ID: "supervised-plugin", Step: StepEndpoint, ExitCode: 0},
+	}, results)
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	require.Equal(t, []EventType{
+		EventRegistered, EventStarted, EventExited, EventRestarted, EventRegistered, EventStarted, EventStopped, EventStopped,
+	}, events)
+}
+
+func TestShutdownDuringRestart(t *testing.T) {
+	registry := NewRegistry(t.Context())
+
+	// Restarted processes take a while to start and tell their PID first.
+	dir := t.TempDir()
+	restarting := filepath.Join(dir, "restarting")
+	prelude := fmt.Sprintf(`if [ -f %[1]s/started ]; then echo $$ > %[2]s; sleep 0.5; fi; touch %[1]s/started`, dir, restarting)
+	require.NoError(t, registry.AddExternalPlugin(testPlugin(t, "restarting-plugin", testPluginServe, prelude), WithRestartPolicy(RestartPolicy{
+		Mode:           RestartAlways,
+		InitialBackoff: 10 * time.Millisecond,
+	})))
+	ext, err := registry.external("restarting-plugin")
+	require.NoError(t, err)
+	require.NoError(t, syscall.Kill(ext.wrapper.cmd().Process.Pid, syscall.SIGKILL))
+
+	var pid int
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(restarting)
+		if err != nil {
+			return false
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(content)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The plugin is shut down while the supervisor waits for the new process to become ready.
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	results, err := registry.Shutdown(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ShutdownResult{{ID: "restarting-plugin", Step: StepNotRunning, ExitCode: -1}}, results)
+
+	// The new process was killed instead of being supervised.
+	require.Equal(t, syscall.ESRCH, syscall.Kill(pid, 0))
+	state, _ := ext.wrapper.status()
+	require.NotEqual(t, stateRunning, state)
+}
+
+func TestRegistryMultipleProviders(t *testing.T) {
+	ctx := t.Context()
+	registry := NewRegistry(ctx)
+
+	firstPlugin := testPlugin(t, "first", testPluginServe, "")
+	firstPlugin.Types = map[string][]types.TypeInfo{"dataProcessor": {{Type: "json"}}}
+	secondPlugin := testPlugin(t, "second", testPluginServe, "")
+	secondPlugin.Types = map[string][]types.TypeInfo{"dataProcessor": {{Type: "yaml"}}}
+	require.NoError(t, errors.Join(registry.AddExternalPlugins(ctx, []types.Plugin{firstPlugin, secondPlugin}, 2)...))
+
+	first, err := registry.external("first")
+	require.NoError(t, err)
+	second, err := registry.external("second")
+	require.NoError(t, err)
+
+	require.Len(t, registry.GetPlugins(ctx, "dataProcessor"), 2)
+	require.Empty(t, registry.GetPlugins(ctx, "unknown"))
+
+	plugin, err := registry.GetPlugin(ctx, "dataProcessor")
+	require.NoError(t, err)
+	require.Equal(t, first.Client, plugin)
+
+	plugin, err = registry.GetPlugin(ctx, "dataProcessor", WithSelector(ByID("second")))
+	require.NoError(t, err)
+	require.Equal(t, second.Client, plugin)
+
+	_, err = registry.GetPlugin(ctx, "dataProcessor", WithSelector(ByID("third")))
+	require.Error(t, err)
+
+	plugin, err = registry.GetPlugin(ctx, "dataProcessor", WithSelector(BySubType("yaml")))
+	require.NoError(t, err)
+	require.Equal(t, second.Client, plugin)
+
+	registry.SetSelector("dataProcessor", RoundRobin())
+	var ids []string
+	for range 3 {
+		plugin, err := registry.GetPlugin(ctx, "dataProcessor")
+		require.NoError(t, err)
+		ids = append(ids, plugin.(*ExternalPluginWrapper).plugin.ID)
+	}
+	require.Equal(t, []string{"first", "second", "first"}, ids)
+
+	first.wrapper.inFlight.Add(2)
+	plugin, err = registry.GetPlugin(ctx, "dataProcessor", WithSelector(LeastInFlight()))
+	require.NoError(t, err)
+	require.Equal(t, second.Client, plugin)
+}
+
+func TestRegistrySubTypes(t *testing.T) {
+	ctx := t.Context()
+	registry := NewRegistry(ctx)
+	require.NoError(t, registry.RegisterInternal("transformer", &MockPlugin{name: "internal"}))
+
+	firstPlugin := testPlugin(t, "first", testPluginServe, "")
+	firstPlugin.Types = map[string][]types.TypeInfo{"dataProcessor": {{Type: "json"}, {Type: "xml"}}}
+	secondPlugin := testPlugin(t, "second", testPluginServe, "")
+	secondPlugin.Types = map[string][]types.TypeInfo{"dataProcessor": {{Type: "yaml"}, {Type: "xml"}}}
+	require.NoError(t, errors.Join(registry.AddExternalPlugins(ctx, []types.Plugin{firstPlugin, secondPlugin}, 2)...))
+
+	first, err := registry.external("first")
+	require.NoError(t, err)
+	second, err := registry.external("second")
+	require.NoError(t, err)
+
+	plugin, err := registry.GetPluginFor(ctx, "dataProcessor", "yaml")
+	require.NoError(t, err)
+	require.Equal(t, second.Client, plugin)
+
+	plugin, err = registry.GetPluginFor(ctx, "dataProcessor", "xml")
+	require.NoError(t, err)
+	require.Equal(t, first.Client, plugin)
+
+	_, err = registry.GetPluginFor(ctx, "dataProcessor", "csv")
+	require.Error(t, err)
+
+	_, err = registry.GetPluginFor(ctx, "transformer", "anything")
+	require.Error(t, err)
+
+	require.Equal(t, []TypeEntry{
+		{Type: "dataProcessor", SubType: "json", PluginID: "first"},
+		{Type: "dataProcessor", SubType: "xml", PluginID: "first"},
+		{Type: "dataProcessor", SubType: "xml", PluginID: "second"},
+		{Type: "dataProcessor", SubType: "yaml", PluginID: "second"},
+		{Type: "transformer", Internal: true},
+	}, registry.ListTypes())
+}
+
+func TestSchemaValidation(t *testing.T) {
+	plugin := types.Plugin{
+		ID: "schema-plugin",
+		Types: map[string][]types.TypeInfo{
+			"dataProcessor": {
+				{
+					Type:       "json",
+					JSONSchema: []byte(`{"type": "object", "properties": {"format": {"type": "string"}}, "required": ["format"]}`),
+				},
+				{Type: "no-schema"},
+			},
+			"transformer": {
+				{
+					Type:       "json",
+					JSONSchema: []byte(`{"type": "object", "required": ["transformation"]}`),
+				},
+			},
+		},
+	}
+
+	schemas, err := compileSchemas(plugin)
+	require.NoError(t, err)
+
+	wrapper := &ExternalPluginWrapper{
+		connectionType: types.TCP,
+		plugin:         &plugin,
+		schemas:        schemas,
+		state:          stateRunning,
+		client:         &http.Client{},
+		location:       "http://127.0.0.1:1",
+	}
+
+	err = wrapper.CallPlugin(context.Background(), "/process", http.MethodPost,
+		plugins.WithPayload(map[string]any{"format": 42}),
+		plugins.WithSchemaValidation("dataProcessor", "json"),
+	)
+	var schemaErr *SchemaError
+	require.ErrorAs(t, err, &schemaErr)
+	require.Equal(t, []SchemaViolation{{Path: "/format", Message: "got number, want string"}}, schemaErr.Violations)
+
+	err = wrapper.CallPlugin(context.Background(), "/process", http.MethodPost,
+		plugins.WithPayload(map[string]any{}),
+		plugins.WithSchemaValidation("dataProcessor", "json"),
+	)
+	require.ErrorAs(t, err, &schemaErr)
+	require.Equal(t, "/", schemaErr.Violations[0].Path)
+	require.Contains(t, schemaErr.Violations[0].Message, "format")
+
+	err = wrapper.CallPlugin(context.Background(), "/process", http.MethodPost,
+		plugins.WithPayload(map[string]any{}),
+		plugins.WithSchemaValidation("dataProcessor", "no-schema"),
+	)
+	require.ErrorContains(t, err, "declared no JSON schema")
+
+	// Sub-types of different types with the same name have their own schemas.
+	err = wrapper.CallPlugin(context.Background(), "/transform", http.MethodPost,
+		plugins.WithPayload(map[string]any{"format": "text"}),
+		plugins.WithSchemaValidation("transformer", "json"),
+	)
+	require.ErrorAs(t, err, &schemaErr)
+	require.Contains(t, schemaErr.Violations[0].Message, "transformation")
+
+	// A valid payload passes validation and fails on sending instead
+	err = wrapper.CallPlugin(context.Background(), "/process", http.MethodPost,
+		plugins.WithPayload(map[string]any{"format": "text"}),
+		plugins.WithSchemaValidation("dataProcessor", "json"),
+	)
+	require.ErrorContains(t, err, "failed to send request to plugin")
+}
+
+func TestInvalidSchemaRejected(t *testing.T) {
+	registry := NewRegistry(context.Background())
+
+	err := registry.AddExternalPlugin(types.Plugin{
+		ID: "broken-schema",
+		Types: map[string][]types.TypeInfo{
+			"dataProcessor": {{Type: "json", JSONSchema: []byte(`{"type": "not-a-type"}`)}},
+		},
+	}, WithLazyStart())
+	require.ErrorContains(t, err, `invalid JSON schema for type "dataProcessor" sub-type "json" of plugin broken-schema`)
+}
+
+func TestGetTyped(t *testing.T) {
+	ctx := t.Context()
+	registry := NewRegistry(ctx)
+
+	plugin := testPlugin(t, "processor", testPluginServe, "")
+	plugin.Types = map[string][]types.TypeInfo{"dataProcessor": {{Type: "upper"}}}
+	require.NoError(t, registry.AddExternalPlugin(plugin))
+
+	processor, err := GetTyped[contracts.DataProcessor](ctx, registry, "dataProcessor")
+	require.NoError(t, err)
+
+	result, err := processor.ProcessData(ctx, []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "HELLO", string(result))
+
+	formats, err := processor.GetSupportedFormats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"text"}, formats)
+
+	processor, err = GetTypedFor[contracts.DataProcessor](ctx, registry, "dataProcessor", "upper")
+	require.NoError(t, err)
+	require.NoError(t, processor.Ping(ctx))
+
+	// Internal plugins are returned as they are
+	require.NoError(t, registry.RegisterInternal("internal", &MockPlugin{name: "internal"}))
+	base, err := GetTyped[contracts.PluginBase](ctx, registry, "internal")
+	require.NoError(t, err)
+	require.IsType(t, &MockPlugin{}, base)
+
+	_, err = GetTyped[contracts.Transformer](ctx, registry, "internal")
+	require.ErrorContains(t, err, "does not implement contracts.Transformer")
+}
+
+func TestTransformerClient(t *testing.T) {
+	var received contracts.TransformRequest
+	var listed atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /transformations", func(w http.ResponseWriter, r *http.Request) {
+		listed.Add(1)
+		require.NoError(t, json.NewEncoder(w).Encode(contracts.TransformationsResponse{
+			Transformations: []contracts.TransformationInfo{
+				{
+					Name: "repeat",
+					Parameters: []contracts.ParameterInfo{
+						{Name: "times", Type: contracts.ParameterTypeInteger, Default: 2},
+						{Name: "separator", Type: contracts.ParameterTypeString, Required: true},
+					},
+				},
+			},
+		}))
+	})
+	mux.HandleFunc("POST /transform", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		require.NoError(t, json.NewEncoder(w).Encode(contracts.TransformResponse{Data: []byte("done")}))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wrapper := &ExternalPluginWrapper{
+		connectionType: types.TCP,
+		plugin:         &types.Plugin{ID: "transformer"},
+		state:          stateRunning,
+		client:         server.Client(),
+		location:       server.URL,
+	}
+
+	transformer, err := As[contracts.Transformer](wrapper)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	response, err := transformer.Transform(ctx, &contracts.TransformRequest{
+		Transformation: "repeat",
+		Parameters:     map[string]interface{}{"separator": ","},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "done", string(response.Data))
+	require.Equal(t, map[string]interface{}{"separator": ",", "times": float64(2)}, received.Parameters)
+
+	_, err = transformer.Transform(ctx, &contracts.TransformRequest{
+		Transformation: "repeat",
+		Parameters:     map[string]interface{}{"times": "three", "unknown": true},
+	})
+	require.ErrorContains(t, err, `unknown parameter "unknown"`)
+	require.ErrorContains(t, err, `parameter "times" must be of type integer, got string`)
+	require.ErrorContains(t, err, `missing required parameter "separator"`)
+
+	_, err = transformer.Transform(ctx, &contracts.TransformRequest{
+		Transformation: "repeat",
+		Parameters:     map[string]interface{}{"times": 2.5, "separator": ""},
+	})
+	require.ErrorContains(t, err, `parameter "times" must be of type integer, got float64`)
+
+	// The client and its declared transformations are reused for the plugin.
+	again, err := As[contracts.Transformer](wrapper)
+	require.NoError(t, err)
+	require.Same(t, transformer, again)
+	require.Equal(t, int32(1), listed.Load())
+
+	_, err = transformer.Transform(ctx, &contracts.TransformRequest{Transformation: "reverse"})
+	require.ErrorContains(t, err, `does not offer transformation "reverse"`)
+	require.Equal(t, int32(2), listed.Load())
+
+	// A new plugin process may declare other transformations, so they are fetched again.
+	wrapper.swap(nil, &plugins.Connection{Client: server.Client(), Location: server.URL})
+	_, err = transformer.Transform(ctx, &contracts.TransformRequest{
+		Transformation: "repeat",
+		Parameters:     map[string]interface{}{"separator": ","},
+	})
 	require.NoError(t, err)
+	require.Equal(t, int32(3), listed.Load())
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "my-plugin")
+	require.NoError(t, os.WriteFile(binary, []byte("plugin"), 0o700))
+
+	plugin := types.Plugin{ID: "my-plugin", Path: binary}
+	require.NoError(t, verifyChecksum(plugin))
+
+	plugin.Checksum = "sha256:c16bd4f1bb4d9fcd2fbc9a5d21ed3a85b2b8b2f8e5fba1ac4b34e1ec3f6c1d8e"
+	require.ErrorContains(t, verifyChecksum(plugin), "changed since it was verified")
+
+	sum := sha256.Sum256([]byte("plugin"))
+	plugin.Checksum = "sha256:" + hex.EncodeToString(sum[:])
+	require.NoError(t, verifyChecksum(plugin))
+}
+
+func TestAddExternalPlugins(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(ctx)
+
+	newPlugin := func(id, subType string) types.Plugin {
+		return types.Plugin{
+			ID:     id,
+			Path:   "/nonexistent/" + id,
+			Config: types.Config{ID: id, Type: types.Socket},
+			Types:  map[string][]types.TypeInfo{"dataProcessor": {{Type: subType}}},
+		}
+	}
+
+	errs := registry.AddExternalPlugins(ctx, []types.Plugin{
+		newPlugin("b-plugin", "first"),
+		newPlugin("a-plugin", "second"),
+		newPlugin("b-plugin", "duplicate"),
+	}, 4, WithLazyStart())
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	require.ErrorIs(t, errs[2], ErrAlreadyRegistered)
+
+	// The order of the batch is kept regardless of the concurrency.
+	require.Equal(t, []TypeEntry{
+		{Type: "dataProcessor", SubType: "first", PluginID: "b-plugin"},
+		{Type: "dataProcessor", SubType: "second", PluginID: "a-plugin"},
+	}, registry.ListTypes())
+
+	// Plugins that fail to start are removed again.
+	errs = registry.AddExternalPlugins(ctx, []types.Plugin{
+		newPlugin("c-plugin", "third"),
+		newPlugin("d-plugin", "fourth"),
+	}, 2)
+	require.Error(t, errs[0])
+	require.Error(t, errs[1])
+	require.Len(t, registry.GetPlugins(ctx, "dataProcessor"), 2)
+
+	_, err := registry.GetPluginFor(ctx, "dataProcessor", "third")
+	require.Error(t, err)
+}
+
+func TestReplaceAndUnregisterPlugin(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(ctx)
+
+	newPlugin := func(id string, pluginTypes map[string][]types.TypeInfo) types.Plugin {
+		return types.Plugin{
+			ID:     id,
+			Path:   "/nonexistent/" + id,
+			Config: types.Config{ID: id, Type: types.Socket},
+			Types:  pluginTypes,
+		}
+	}
+
+	errs := registry.AddExternalPlugins(ctx, []types.Plugin{
+		newPlugin("a-plugin", map[string][]types.TypeInfo{"dataProcessor": {{Type: "a"}}, "transformer": {{Type: "a"}}}),
+		newPlugin("b-plugin", map[string][]types.TypeInfo{"dataProcessor": {{Type: "b"}}}),
+	}, 1, WithLazyStart())
+	require.NoError(t, errors.Join(errs...))
+
+	// A replacement that can't start leaves the old plugin in place.
+	err := registry.ReplacePlugin(ctx, newPlugin("a-plugin", map[string][]types.TypeInfo{"dataProcessor": {{Type: "broken"}}}))
+	require.Error(t, err)
+	require.Contains(t, registry.ListTypes(), TypeEntry{Type: "dataProcessor", SubType: "a", PluginID: "a-plugin"})
+
+	err = registry.ReplacePlugin(ctx, newPlugin("a-plugin", map[string][]types.TypeInfo{"dataProcessor": {{Type: "a2"}}}), WithLazyStart())
+	require.NoError(t, err)
+	require.Equal(t, []TypeEntry{
+		{Type: "dataProcessor", SubType: "a2", PluginID: "a-plugin"},
+		{Type: "dataProcessor", SubType: "b", PluginID: "b-plugin"},
+	}, registry.ListTypes())
+
+	// The replacement keeps the priority of the old plugin and gets its own instance name.
+	plugins := registry.GetPlugins(ctx, "dataProcessor")
+	require.Equal(t, "a-plugin", plugins[0].(*ExternalPluginWrapper).GetID())
+	replacement, err := registry.external("a-plugin")
+	require.NoError(t, err)
+	require.NotEmpty(t, replacement.Plugin.Config.Instance)
+
+	err = registry.ReplacePlugin(ctx, newPlugin("c-plugin", nil), WithLazyStart())
+	require.ErrorIs(t, err, ErrPluginNotFound)
+
+	require.NoError(t, registry.UnregisterPlugin(ctx, "a-plugin"))
+	require.ErrorIs(t, registry.UnregisterPlugin(ctx, "a-plugin"), ErrPluginNotFound)
+	require.Equal(t, []TypeEntry{
+		{Type: "dataProcessor", SubType: "b", PluginID: "b-plugin"},
+	}, registry.ListTypes())
+}
+
+func TestShutdownEscalation(t *testing.T) {
+	registry := NewRegistry(context.Background())
+
+	// Plugins that ignore SIGTERM are killed, and the socket files they leave behind are removed.
+	killPlugin := testPlugin(t, "kill-plugin", testPluginIgnoreTerm, "")
+	killPlugin.Config.Type = types.Socket
+	killPlugin.Config.RuntimeDir = t.TempDir()
+	socket := filepath.Join(killPlugin.Config.RuntimeDir, "kill-plugin.socket")
+
+	errs := registry.AddExternalPlugins(context.Background(), []types.Plugin{
+		// Plugins that accept the shutdown request exit on their own.
+		testPlugin(t, "endpoint-plugin", testPluginServe, ""),
+		// Plugins without a shutdown endpoint are terminated.
+		testPlugin(t, "term-plugin", testPluginIgnoreShutdown, ""),
+		killPlugin,
+	}, 3)
+	require.NoError(t, errors.Join(errs...))
+	require.FileExists(t, socket)
+	require.FileExists(t, socket+".lock")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	results, err := registry.Shutdown(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []ShutdownResult{
+		{ID: "endpoint-plugin", Step: StepEndpoint, ExitCode: 0},
+		{ID: "kill-plugin", Step: StepKill, ExitCode: -1},
+		{ID: "term-plugin", Step: StepTerminate, ExitCode: -1},
+	}, results)
+
+	require.NoFileExists(t, socket)
+	require.NoFileExists(t, socket+".lock")
+}
+
+func TestReloadConfigAndEvents(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(ctx)
+
+	require.ErrorIs(t, registry.RestartPlugin(ctx, "reload-plugin"), ErrPluginNotFound)
+
+	require.NoError(t, registry.AddExternalPlugin(testPlugin(t, "reload-plugin", testPluginServe, "")))
+	ext, err := registry.external("reload-plugin")
+	require.NoError(t, err)
+
+	var events []EventType
+	registry.AddEventHandler(func(event Event) {
+		require.Equal(t, "reload-plugin", event.PluginID)
+		events = append(events, event.Type)
+	})
+
+	// The old process is stopped and the new configuration is used for the next one.
+	configData := []types.ConfigData{{Type: "valid"}}
+	require.NoError(t, registry.ReloadConfig(ctx, "reload-plugin", configData))
+	require.Equal(t, configData, ext.Plugin.Config.ConfigTypes)
+	require.Equal(t, []EventType{EventDraining, EventStopped, EventStarted, EventConfigReloaded}, events)
+
+	// A configuration the plugin fails to start with is rolled back, without disturbing readers
+	// of the plugin's types and process.
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = registry.ListTypes()
+				_ = ext.wrapper.cmd()
+			}
+		}
+	}()
+	events = nil
+	err = registry.ReloadConfig(ctx, "reload-plugin", []types.ConfigData{{Type: testPluginFailConfig}})
+	close(done)
+	readers.Wait()
+	require.ErrorIs(t, err, plugins.ErrHandshake)
+	require.Equal(t, configData, ext.Plugin.Config.ConfigTypes)
+	require.Equal(t, []EventType{EventDraining, EventStopped, EventStarted}, events)
+
+	state, _ := ext.wrapper.status()
+	require.Equal(t, stateRunning, state)
+	require.False(t, ext.stopping.Load())
+
+	processor, err := As[contracts.DataProcessor](ext.wrapper)
+	require.NoError(t, err)
+	data, err := processor.ProcessData(ctx, []byte("still running"))
+	require.NoError(t, err)
+	require.Equal(t, "STILL RUNNING", string(data))
+
+	events = nil
+	require.NoError(t, registry.UnregisterPlugin(ctx, "reload-plugin"))
+	require.Equal(t, []EventType{EventDraining, EventStopped, EventUnregistered}, events)
+	require.Empty(t, registry.ListTypes())
+}
+
+func TestRestartUnregisteredPlugin(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(ctx)
+
+	require.NoError(t, registry.AddExternalPlugin(testPlugin(t, "retired-plugin", testPluginServe, "")))
+	ext, err := registry.external("retired-plugin")
+	require.NoError(t, err)
+
+	// A restart that looked the plugin up before it was unregistered doesn't start it again.
+	require.NoError(t, registry.UnregisterPlugin(ctx, "retired-plugin"))
+	require.ErrorIs(t, registry.restartPlugin(ctx, ext, false, nil), ErrPluginNotFound)
+	state, _ := ext.wrapper.status()
+	require.NotEqual(t, stateRunning, state)
+	require.ErrorIs(t, registry.ensureStarted(ctx, ext), ErrPluginExited)
+
+	// The same goes for plugins that were shut down.
+	require.NoError(t, registry.AddExternalPlugin(testPlugin(t, "shut-down-plugin", testPluginServe, "")))
+	ext, err = registry.external("shut-down-plugin")
+	require.NoError(t, err)
+	_, err = registry.Shutdown(ctx)
+	require.NoError(t, err)
+	require.ErrorIs(t, registry.restartPlugin(ctx, ext, true, nil), ErrPluginNotFound)
+	state, _ = ext.wrapper.status()
+	require.NotEqual(t, stateRunning, state)
+}
+
+func TestPluginOutput(t *testing.T) {
+	var logs strings.Builder
+	output := newPluginOutput("output-plugin", 3, slog.New(slog.NewJSONHandler(&logs, nil)))
+	cmd := exec.Command("sh", "-c", `echo '{"time":"2025-01-01T00:00:00Z","level":"WARN","msg":"disk almost full","free":5,"plugin_id":"other-plugin"}' >&2; printf 'one\ntwo\n\nthree\nfour' >&2`)
+	cmd.Stderr = output.writer(StreamStderr, cmd)
+	require.NoError(t, cmd.Run())
+	flushOutput(cmd)
+	output.forward(StreamStdout, cmd, strings.NewReader("five\n"))
+
+	// Only the most recent lines are kept, and lines without a newline are routed once they end.
+	lines := output.recent()
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+		require.Equal(t, cmd.Process.Pid, line.PID)
+	}
+	require.Equal(t, []string{"three", "four", "five"}, texts)
+	require.Equal(t, StreamStdout, lines[2].Stream)
+
+	// JSON log lines are logged with their level and attributes, other lines are wrapped.
+	var records []map[string]any
+	for line := range strings.Lines(logs.String()) {
+		record := map[string]any{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 6)
+	require.Equal(t, "WARN", records[0]["level"])
+	require.Equal(t, "disk almost full", records[0]["msg"])
+	require.Equal(t, "output-plugin", records[0]["plugin_id"])
+	require.InDelta(t, float64(cmd.Process.Pid), records[0]["pid"], 0)
+	// The plugin's attributes can't pass for the attribution of the line.
+	require.Equal(t, map[string]any{"free": float64(5), "plugin_id": "other-plugin"}, records[0]["attrs"])
+	require.Equal(t, "plugin output", records[1]["msg"])
+	require.Equal(t, "one", records[1]["line"])
+
+	// JSON that isn't a slog record is logged verbatim.
+	logs.Reset()
+	other := exec.Command("sh", "-c", `echo null >&2; echo '{"status":"ok"}' >&2; echo '{"level":"LOUD","msg":"x"}' >&2`)
+	other.Stderr = output.writer(StreamStderr, other)
+	require.NoError(t, other.Run())
+	var verbatim []string
+	for line := range strings.Lines(logs.String()) {
+		record := map[string]any{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		require.Equal(t, "plugin output", record["msg"])
+		require.Equal(t, "INFO", record["level"])
+		verbatim = append(verbatim, record["line"].(string))
+	}
+	require.Equal(t, []string{"null", `{"status":"ok"}`, `{"level":"LOUD","msg":"x"}`}, verbatim)
+
+	// The output of every registered plugin is kept.
+	registry := NewRegistry(t.Context())
+	require.NoError(t, registry.AddExternalPlugin(testPlugin(t, "echo-plugin", testPluginServe, "echo started >&2")))
+	ext, err := registry.external("echo-plugin")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		lines, err := registry.PluginOutput("echo-plugin")
+		return err == nil && len(lines) == 1 && lines[0].Text == "started" && lines[0].PID == ext.wrapper.cmd().Process.Pid
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = registry.PluginOutput("missing-plugin")
+	require.ErrorIs(t, err, ErrPluginNotFound)
 }
@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// SchemaViolation describes a single location in a payload that doesn't match its schema.
+type SchemaViolation struct {
+	// Path is the JSON pointer of the failing value in the payload, for example /format.
+	Path string
+	// Message describes why the value is invalid.
+	Message string
+}
+
+// SchemaError is returned when a payload doesn't match the JSON schema declared for a sub-type.
+type SchemaError struct {
+	SubType    string
+	Violations []SchemaViolation
+}
+
+// Error implements the error interface.
+func (e *SchemaError) Error() string {
+	details := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		details = append(details, fmt.Sprintf("at '%s': %s", v.Path, v.Message))
+	}
+
+	return fmt.Sprintf("payload does not match the schema of sub-type %q: %s", e.SubType, strings.Join(details, "; "))
+}
+
+// typeSchemas holds compiled JSON schemas by plugin type and sub-type.
+type typeSchemas map[string]map[string]*jsonschema.Schema
+
+// compileSchemas compiles every JSON schema the plugin declared for its types. Sub-types without a
+// schema are skipped. Schemas may not reference anything outside themselves.
+func compileSchemas(plugin types.Plugin) (typeSchemas, error) {
+	schemas := make(typeSchemas)
+	for pluginType, infos := range plugin.Types {
+		for _, info := range infos {
+			if len(info.JSONSchema) == 0 {
+				continue
+			}
+
+			schema, err := compileSchema(plugin.ID+"/"+pluginType+"/"+info.Type, info.JSONSchema)
+			if err != nil {
+				return nil, fmt.Errorf("%w for type %q sub-type %q of plugin %s: %w", ErrInvalidSchema, pluginType, info.Type, plugin.ID, err)
+			}
+
+			if schemas[pluginType] == nil {
+				schemas[pluginType] = make(map[string]*jsonschema.Schema)
+			}
+			schemas[pluginType][info.Type] = schema
+		}
+	}
+
+	return schemas, nil
+}
+
+func compileSchema(name string, content []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	url := "mem://schemas/" + name
+	compiler := jsonschema.NewCompiler()
+	// don't load any referenced schemas from the file system or the network
+	compiler.UseLoader(jsonschema.SchemeURLLoader{})
+	if err := compiler.AddResource(url, doc); err != nil {
+		return nil, err
+	}
+
+	return compiler.Compile(url)
+}
+
+// find returns the schema declared for the sub-type of the plugin type.
+func (s typeSchemas) find(pluginType, subType string) (*jsonschema.Schema, bool) {
+	schema, ok := s[pluginType][subType]
+
+	return schema, ok
+}
+
+// validatePayload validates a serialized payload against the schema of the sub-type.
+func validatePayload(schema *jsonschema.Schema, subType string, payload []byte) error {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to parse payload for validation: %w", err)
+	}
+
+	err = schema.Validate(doc)
+	if err == nil {
+		return nil
+	}
+
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return fmt.Errorf("failed to validate payload: %w", err)
+	}
+
+	result := &SchemaError{SubType: subType}
+	for _, unit := range validationErr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+
+		path := unit.InstanceLocation
+		if path == "" {
+			path = "/"
+		}
+		result.Violations = append(result.Violations, SchemaViolation{
+			Path:    path,
+			Message: unit.Error.String(),
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// Selector picks the external plugin that serves a lookup out of all external plugins
+// registered for the plugin type. Candidates are passed in registration order and there
+// is always at least one.
+type Selector func(pluginType string, candidates []*ExternalPlugin) (*ExternalPlugin, error)
+
+// FirstRegistered selects the plugin that was registered first for the type.
+func FirstRegistered() Selector {
+	return func(_ string, candidates []*ExternalPlugin) (*ExternalPlugin, error) {
+		return candidates[0], nil
+	}
+}
+
+// ByID selects the plugin with the given ID.
+func ByID(id string) Selector {
+	return func(pluginType string, candidates []*ExternalPlugin) (*ExternalPlugin, error) {
+		for _, candidate := range candidates {
+			if candidate.Plugin.ID == id {
+				return candidate, nil
+			}
+		}
+
+		return nil, fmt.Errorf("no plugin with id %q found for type %q", id, pluginType)
+	}
+}
+
+// BySubType selects the first registered plugin that declares the given sub-type
+// in its TypeInfo list for the plugin type.
+func BySubType(subType string) Selector {
+	return func(pluginType string, candidates []*ExternalPlugin) (*ExternalPlugin, error) {
+		for _, candidate := range candidates {
+			if candidate.declares(pluginType, subType) {
+				return candidate, nil
+			}
+		}
+
+		return nil, fmt.Errorf("no plugin found for type %q with sub-type %q", pluginType, subType)
+	}
+}
+
+// RoundRobin rotates through the plugins of a type on every lookup. The returned selector
+// keeps its own position per type, so it should be created once and reused.
+func RoundRobin() Selector {
+	var mu sync.Mutex
+	next := make(map[string]int)
+
+	return func(pluginType string, candidates []*ExternalPlugin) (*ExternalPlugin, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		i := next[pluginType] % len(candidates)
+		next[pluginType] = i + 1
+
+		return candidates[i], nil
+	}
+}
+
+// LeastInFlight selects the plugin with the fewest calls currently in progress.
+// Ties are resolved in favour of the plugin registered first.
+func LeastInFlight() Selector {
+	return func(_ string, candidates []*ExternalPlugin) (*ExternalPlugin, error) {
+		return slices.MinFunc(candidates, func(a, b *ExternalPlugin) int {
+			return int(a.wrapper.InFlight() - b.wrapper.InFlight())
+		}), nil
+	}
+}
+
+// declares reports whether the plugin lists subType for pluginType in its capabilities.
+func (ext *ExternalPlugin) declares(pluginType, subType string) bool {
+	return slices.ContainsFunc(ext.Plugin.Types[pluginType], func(info types.TypeInfo) bool {
+		return info.Type == subType
+	})
+}
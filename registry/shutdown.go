@@ -0,0 +1,215 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// defaultStopStep bounds every step of the shutdown sequence if the context has no deadline.
+const defaultStopStep = 5 * time.Second
+
+// ShutdownStep is the step of the shutdown sequence a plugin process stopped at.
+type ShutdownStep string
+
+const (
+	// StepNotRunning means the plugin had no process to stop.
+	StepNotRunning ShutdownStep = "not-running"
+	// StepEndpoint means the plugin exited after a request to its /shutdown endpoint.
+	StepEndpoint ShutdownStep = "endpoint"
+	// StepTerminate means the plugin exited after SIGTERM.
+	StepTerminate ShutdownStep = "terminate"
+	// StepKill means the plugin had to be killed.
+	StepKill ShutdownStep = "kill"
+)
+
+// ShutdownResult describes how a plugin process was stopped.
+type ShutdownResult struct {
+	// ID is the ID of the plugin.
+	ID string
+	// Step is the step of the shutdown sequence the process exited at.
+	Step ShutdownStep
+	// ExitCode is the exit code of the process. It is -1 if the process was terminated by a
+	// signal or wasn't running.
+	ExitCode int
+	// Err is set if the process couldn't be stopped.
+	Err error
+}
+
+// Shutdown stops every external plugin process concurrently and reports how each of them was
+// stopped. Every plugin is first asked to stop through its /shutdown endpoint, then its process
+// group is sent SIGTERM and finally killed. Each step waits at most half of the time left until
+// the deadline of ctx, or 5 seconds if ctx has none. Processes are reaped and socket files they
+// left behind are removed.
+func (r *Registry) Shutdown(ctx context.Context) ([]ShutdownResult, error) {
+	r.mu.RLock()
+	externalPlugins := make([]*ExternalPlugin, 0, len(r.pluginsByID))
+	for _, externalPlugin := range r.pluginsByID {
+		externalPlugin.retired.Store(true)
+		externalPlugin.stopping.Store(true)
+		externalPlugins = append(externalPlugins, externalPlugin)
+	}
+	r.mu.RUnlock()
+
+	results := make([]ShutdownResult, len(externalPlugins))
+	var wg sync.WaitGroup
+	for i, externalPlugin := range externalPlugins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = r.stop(ctx, externalPlugin)
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(results, func(a, b ShutdownResult) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// stop stops the plugin process and waits for the supervisor to reap it.
+func (r *Registry) stop(ctx context.Context, ext *ExternalPlugin) ShutdownResult {
+	ext.startMu.Lock()
+	defer ext.startMu.Unlock()
+
+	return r.stopLocked(ctx, ext)
+}
+
+// stopLocked runs the shutdown sequence for the plugin process. The caller has to hold ext.startMu.
+func (r *Registry) stopLocked(ctx context.Context, ext *ExternalPlugin) ShutdownResult {
+	ext.stopping.Store(true)
+
+	result := ShutdownResult{ID: ext.Plugin.ID, Step: StepNotRunning, ExitCode: -1}
+	if state, _ := ext.wrapper.status(); state != stateRunning {
+		result.Err = r.waitSupervisor(ctx, ext)
+		return result
+	}
+
+	cmd := ext.wrapper.cmd()
+	result.Step, result.Err = r.terminate(ctx, ext)
+	if result.Err != nil {
+		return result
+	}
+
+	result.ExitCode = cmd.ProcessState.ExitCode()
+	removeSocket(ext)
+	slog.InfoContext(ctx, "plugin stopped", "id", ext.Plugin.ID, "step", result.Step, "exit-code", result.ExitCode)
+	r.emit(EventStopped, ext.Plugin.ID, nil)
+
+	return result
+}
+
+// terminate escalates from the /shutdown endpoint over SIGTERM to SIGKILL until the running
+// plugin process exits, and returns the step it exited at.
+func (r *Registry) terminate(ctx context.Context, ext *ExternalPlugin) (ShutdownStep, error) {
+	process := ext.wrapper.cmd().Process
+
+	if err := requestShutdown(ctx, ext.wrapper); err != nil {
+		slog.DebugContext(ctx, "plugin didn't accept the shutdown request", "id", ext.Plugin.ID, "error", err)
+	} else if awaitExit(ctx, ext) {
+		return StepEndpoint, nil
+	}
+
+	if err := signalGroup(process, syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		slog.DebugContext(ctx, "failed to send SIGTERM to plugin", "id", ext.Plugin.ID, "error", err)
+	} else if awaitExit(ctx, ext) {
+		return StepTerminate, nil
+	}
+
+	slog.WarnContext(ctx, "plugin did not stop in time, killing it", "id", ext.Plugin.ID)
+	if err := signalGroup(process, syscall.SIGKILL); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return StepKill, fmt.Errorf("failed to kill plugin %s: %w", ext.Plugin.ID, err)
+	}
+	<-ext.done
+
+	return StepKill, nil
+}
+
+// requestShutdown calls the /shutdown endpoint of the plugin.
+func requestShutdown(ctx context.Context, w *ExternalPluginWrapper) error {
+	ctx, cancel := context.WithTimeout(ctx, stopStep(ctx))
+	defer cancel()
+
+	w.mu.RLock()
+	client, location := w.client, w.location
+	w.mu.RUnlock()
+
+	return plugins.Call(ctx, client, w.connectionType, location, "/shutdown", http.MethodPost, plugins.WithAuthToken(w.authToken))
+}
+
+// awaitExit waits for one step of the shutdown sequence for the plugin process to be reaped.
+func awaitExit(ctx context.Context, ext *ExternalPlugin) bool {
+	timer := time.NewTimer(stopStep(ctx))
+	defer timer.Stop()
+
+	select {
+	case <-ext.done:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stopStep returns how long a step of the shutdown sequence may take.
+func stopStep(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return defaultStopStep
+	}
+
+	return time.Until(deadline) / 2
+}
+
+// waitSupervisor waits for the supervisor of a plugin that isn't running to return, so it can't
+// restart the plugin anymore. A supervisor waiting for its backoff to pass returns once it passed.
+// One that is starting a new process returns once the process became ready or failed to, and
+// kills the process instead of supervising it.
+func (r *Registry) waitSupervisor(ctx context.Context, ext *ExternalPlugin) error {
+	if ext.done == nil {
+		return nil // Never started
+	}
+
+	select {
+	case <-ext.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("supervisor of plugin %s did not return in time: %w", ext.Plugin.ID, ctx.Err())
+	}
+}
+
+// removeSocket removes the socket and lock file of a plugin process that didn't clean up after itself.
+func removeSocket(ext *ExternalPlugin) {
+	if ext.Plugin.Config.Type != types.Socket {
+		return
+	}
+
+	// The location of a socket plugin is the socket path with an http+unix scheme.
+	location := strings.TrimPrefix(ext.wrapper.GetLocation(), "http+unix://")
+	for _, path := range []string{location, location + ".lock"} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to remove plugin socket", "id", ext.Plugin.ID, "path", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// RestartMode defines when an exited plugin process is started again.
+type RestartMode string
+
+const (
+	// RestartNever leaves an exited plugin stopped.
+	RestartNever RestartMode = "never"
+	// RestartOnFailure restarts a plugin only if it exited with an error or was killed by a signal.
+	RestartOnFailure RestartMode = "on-failure"
+	// RestartAlways restarts a plugin whenever it exits.
+	RestartAlways RestartMode = "always"
+)
+
+const (
+	// outputWaitDelay is how long the output of a plugin is still read after its process exited.
+	outputWaitDelay = time.Second
+	// defaultStartupTimeout bounds the wait for a new plugin process to become ready.
+	defaultStartupTimeout = 30 * time.Second
+)
+
+const (
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	defaultResetAfter     = time.Minute
+)
+
+// RestartPolicy configures how the registry supervises a plugin process.
+type RestartPolicy struct {
+	// Mode decides if a plugin is restarted after it exits.
+	Mode RestartMode
+	// MaxRestarts is the number of consecutive restarts allowed before giving up. Zero or less means no limit.
+	MaxRestarts int
+	// InitialBackoff is the delay before the first restart attempt. It is doubled for every further attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between restart attempts.
+	MaxBackoff time.Duration
+	// ResetAfter is the time a plugin has to stay up for the restart counter and the backoff to be reset.
+	ResetAfter time.Duration
+}
+
+// shouldRestart decides if a plugin that exited with exitErr needs to be started again.
+func (p RestartPolicy) shouldRestart(exitErr error) bool {
+	switch p.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+// backoff returns the delay before the given restart attempt. Attempts start at 1.
+func (p RestartPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+
+	limit := p.MaxBackoff
+	if limit <= 0 {
+		limit = defaultMaxBackoff
+	}
+
+	d := initial
+	for i := 1; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+
+	return min(d, limit)
+}
+
+func (p RestartPolicy) resetAfter() time.Duration {
+	if p.ResetAfter <= 0 {
+		return defaultResetAfter
+	}
+
+	return p.ResetAfter
+}
+
+var (
+	// ErrPluginExited is returned when calling a plugin whose process is no longer running.
+	ErrPluginExited = errors.New("plugin process has exited")
+	// ErrLimitExceeded is part of the exit error of a plugin process that exceeded one of its resource limits.
+	ErrLimitExceeded = errors.New("plugin exceeded its resource limit")
+)
+
+// command creates the command that runs the plugin binary with its configuration.
+func (r *Registry) command(plugin *types.Plugin, ext *ExternalPlugin) (*exec.Cmd, error) {
+	config, secrets := plugin.Config.SplitSecrets()
+	config.ManagerPID = os.Getpid()
+	serialized, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal plugin configuration: %w", err)
+	}
+
+	serializedSecrets, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal plugin secrets: %w", err)
+	}
+
+	cmd := exec.CommandContext(r.ctx, plugin.Path, "--config", string(serialized)) //nolint:gosec // G204 does not apply
+	cmd.Env = append(os.Environ(), types.SecretsEnv+"="+string(serializedSecrets))
+	cmd.Cancel = func() error {
+		slog.Info("killing plugin process because the parent context is cancelled", "id", plugin.ID)
+		return signalGroup(cmd.Process, syscall.SIGKILL)
+	}
+	cmd.Stderr = ext.output.writer(StreamStderr, cmd)
+	// Subprocesses of the plugin may keep its output open, which must not keep Wait from returning.
+	cmd.WaitDelay = outputWaitDelay
+
+	if err := setProcessAttributes(cmd, ext.credential); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// verifyChecksum makes sure the plugin binary wasn't changed since it was verified.
+func verifyChecksum(plugin types.Plugin) error {
+	if plugin.Checksum == "" {
+		return nil
+	}
+
+	file, err := os.Open(plugin.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open plugin binary: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("failed to read plugin binary: %w", err)
+	}
+
+	if checksum := "sha256:" + hex.EncodeToString(hash.Sum(nil)); checksum != plugin.Checksum {
+		return fmt.Errorf("plugin binary %s changed since it was verified (%s != %s)", plugin.Path, checksum, plugin.Checksum)
+	}
+
+	return nil
+}
+
+// pluginProcess is a started plugin process.
+type pluginProcess struct {
+	cmd    *exec.Cmd
+	conn   *plugins.Connection
+	limits *processLimits
+}
+
+// kill kills the process group of a process that was started but isn't needed anymore and reaps it.
+func (p *pluginProcess) kill() {
+	_ = signalGroup(p.cmd.Process, syscall.SIGKILL)
+	_ = p.cmd.Wait()
+	flushOutput(p.cmd)
+	p.limits.release()
+}
+
+// startProcess starts a new process for the plugin and waits for it to report where it can be
+// reached, at most until ctx is done or the startup timeout of the plugin passed. The process is
+// killed if it does not become ready.
+func (r *Registry) startProcess(ctx context.Context, ext *ExternalPlugin) (_ *pluginProcess, err error) {
+	plugin := ext.Plugin
+	if err := verifyChecksum(plugin); err != nil {
+		return nil, err
+	}
+
+	limits, err := newProcessLimits(&plugin, ext.cgroupParent)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			limits.release()
+		}
+	}()
+
+	waitOpts := []plugins.WaitOptionFn{}
+	if ext.ca != nil && plugin.Config.Type == types.TCP {
+		tlsConfig, pinned, err := ext.ca.IssuePluginCertificate(plugin.ID)
+		if err != nil {
+			return nil, err
+		}
+		plugin.Config.TLS = tlsConfig
+		waitOpts = append(waitOpts, plugins.WithTLS(ext.ca.ClientCertificate(), pinned))
+	}
+
+	cmd, err := r.command(&plugin, ext)
+	if err != nil {
+		return nil, err
+	}
+	limits.prepare(cmd)
+
+	// Only the process holds the write end of the pipe once it started, so the handshake is read
+	// from the read end and the output forwarded until the process and its subprocesses exited.
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the stdout pipe of plugin %s: %w", plugin.ID, err)
+	}
+	cmd.Stdout = stdoutWriter
+
+	err = cmd.Start()
+	_ = stdoutWriter.Close()
+	if err != nil {
+		_ = stdout.Close()
+		return nil, fmt.Errorf("failed to start plugin %s: %w", plugin.ID, err)
+	}
+
+	kill := func() {
+		_ = signalGroup(cmd.Process, syscall.SIGKILL)
+		_ = cmd.Wait()
+		_ = stdout.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ext.startupTimeout)
+	defer cancel()
+
+	plugin.Cmd = cmd
+	noise := ext.output.writer(StreamStdout, cmd)
+	waitOpts = append(waitOpts, plugins.WithNoise(noise.route))
+	conn, err := plugins.WaitForPlugin(ctx, &plugin, stdout, waitOpts...)
+	if err != nil {
+		kill()
+		return nil, fmt.Errorf("failed to wait for plugin %s to start: %w", plugin.ID, limits.exitReason(cmd, err))
+	}
+
+	go func() {
+		// conn.Output reads from stdout and may hold what was read past the handshake, so stdout
+		// is only closed once that was forwarded too.
+		ext.output.forward(StreamStdout, cmd, conn.Output)
+		_ = stdout.Close()
+	}()
+
+	return &pluginProcess{cmd: cmd, conn: conn, limits: limits}, nil
+}
+
+// ensureStarted starts the plugin process if it has never been started or if it stopped
+// itself after being idle. Plugins that exited for any other reason are left to the supervisor.
+// ctx bounds the wait for the plugin to become ready, not the lifetime of the process.
+func (r *Registry) ensureStarted(ctx context.Context, ext *ExternalPlugin) error {
+	ext.startMu.Lock()
+	defer ext.startMu.Unlock()
+
+	return r.startLocked(ctx, ext)
+}
+
+// startInitial starts a newly registered plugin. If the plugin doesn't start, it is removed from
+// the registry before anyone else can try to start it.
+func (r *Registry) startInitial(ctx context.Context, ext *ExternalPlugin) error {
+	ext.startMu.Lock()
+	defer ext.startMu.Unlock()
+
+	if err := r.startLocked(ctx, ext); err != nil {
+		ext.retired.Store(true)
+		ext.stopping.Store(true)
+		r.remove(ext)
+		r.emit(EventUnregistered, ext.Plugin.ID, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// startLocked starts the plugin process. The caller has to hold ext.startMu.
+func (r *Registry) startLocked(ctx context.Context, ext *ExternalPlugin) error {
+	state, exitErr := ext.wrapper.status()
+	switch {
+	case state == stateRunning:
+		return nil
+	case state == stateExited || ext.stopping.Load() || ext.retired.Load():
+		return ext.wrapper.exitedError(exitErr)
+	}
+
+	process, err := r.startProcess(ctx, ext)
+	if err != nil {
+		return err
+	}
+
+	ext.wrapper.swap(process.cmd, process.conn)
+	ext.limits = process.limits
+	ext.done = make(chan struct{})
+	go r.supervise(ext, ext.done)
+	r.emit(EventStarted, ext.Plugin.ID, nil)
+
+	return nil
+}
+
+// isIdleExit reports whether the process exited because the plugin was idle for too long.
+func isIdleExit(exitErr error) bool {
+	var ee *exec.ExitError
+	return errors.As(exitErr, &ee) && ee.ExitCode() == types.IdleExitCode
+}
+
+// supervise reaps the plugin process and restarts it according to the plugin's restart policy.
+// New processes are swapped into the existing wrapper so callers holding it keep working.
+// A plugin that stopped because it was idle is not restarted here but on its next use.
+func (r *Registry) supervise(ext *ExternalPlugin, done chan struct{}) {
+	defer close(done)
+
+	attempt := 0
+	for {
+		cmd := ext.wrapper.cmd()
+		started := time.Now()
+		exitErr := cmd.Wait()
+		flushOutput(cmd)
+		if errors.Is(exitErr, exec.ErrWaitDelay) {
+			exitErr = nil // The plugin exited successfully, only its output was still open.
+		}
+		// Subprocesses of the plugin must not outlive it.
+		_ = signalGroup(cmd.Process, syscall.SIGKILL)
+		exitErr = ext.limits.exitReason(cmd, exitErr)
+		ext.limits.release()
+
+		if isIdleExit(exitErr) && !ext.stopping.Load() {
+			ext.wrapper.markExited(stateIdle, nil)
+			slog.InfoContext(r.ctx, "plugin stopped after being idle, it will be started again on demand", "id", ext.Plugin.ID)
+			r.emit(EventIdle, ext.Plugin.ID, nil)
+
+			return
+		}
+
+		ext.wrapper.markExited(stateExited, exitErr)
+
+		if ext.stopping.Load() || r.ctx.Err() != nil {
+			return
+		}
+
+		slog.WarnContext(r.ctx, "plugin process exited", "id", ext.Plugin.ID, "error", exitErr)
+		r.emit(EventExited, ext.Plugin.ID, exitErr)
+
+		if !ext.policy.shouldRestart(exitErr) {
+			return
+		}
+
+		if time.Since(started) >= ext.policy.resetAfter() {
+			attempt = 0
+		}
+
+		if !r.restart(ext, &attempt) {
+			return
+		}
+	}
+}
+
+// restart keeps trying to start a new process for the plugin until it succeeds, the restart
+// budget is used up or the plugin is being stopped. It reports whether a new process is running.
+// The process is started without holding ext.startMu, as stopping the plugin holds it while it
+// waits for the supervisor to return, so a process that became ready after the plugin started
+// being stopped is killed instead of swapped in.
+func (r *Registry) restart(ext *ExternalPlugin, attempt *int) bool {
+	for {
+		*attempt++
+		if ext.policy.MaxRestarts > 0 && *attempt > ext.policy.MaxRestarts {
+			slog.ErrorContext(r.ctx, "plugin exceeded its restart budget, giving up", "id", ext.Plugin.ID, "restarts", ext.policy.MaxRestarts)
+			return false
+		}
+
+		if !r.sleep(ext.policy.backoff(*attempt)) || ext.stopping.Load() {
+			return false
+		}
+
+		process, err := r.startProcess(r.ctx, ext)
+		if err != nil {
+			slog.WarnContext(r.ctx, "failed to restart plugin", "id", ext.Plugin.ID, "attempt", *attempt, "error", err)
+			continue
+		}
+
+		if !ext.wrapper.swapUnlessStopping(&ext.stopping, process.cmd, process.conn) {
+			slog.InfoContext(r.ctx, "plugin is being stopped, killing the process it was restarted with", "id", ext.Plugin.ID)
+			process.kill()
+
+			return false
+		}
+		ext.limits = process.limits
+		slog.InfoContext(r.ctx, "plugin restarted", "id", ext.Plugin.ID, "attempt", *attempt)
+		r.emit(EventRestarted, ext.Plugin.ID, nil)
+
+		return true
+	}
+}
+
+// sleep waits for d or until the registry context is done. It reports whether the full duration passed.
+func (r *Registry) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-r.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/Skarlso/go-plugin-framework/contracts"
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+)
+
+// Endpoint describes a plugin endpoint together with the types it accepts and returns.
+type Endpoint[Req, Resp any] struct {
+	Path   string
+	Method string
+}
+
+// Invoke calls the endpoint on the plugin and decodes the response. The request is only
+// sent as payload for methods that have a body.
+func (e Endpoint[Req, Resp]) Invoke(ctx context.Context, w *ExternalPluginWrapper, req Req, opts ...plugins.CallOptionFn) (Resp, error) {
+	var resp Resp
+
+	callOpts := []plugins.CallOptionFn{plugins.WithResult(&resp)}
+	if e.Method != http.MethodGet && e.Method != http.MethodHead {
+		callOpts = append(callOpts, plugins.WithPayload(req))
+	}
+
+	if err := w.CallPlugin(ctx, e.Path, e.Method, append(callOpts, opts...)...); err != nil {
+		return resp, fmt.Errorf("failed to call %s %s: %w", e.Method, e.Path, err)
+	}
+
+	return resp, nil
+}
+
+// Canonical endpoints of the contracts shipped with the framework.
+var (
+	ProcessDataEndpoint = Endpoint[contracts.DataProcessorRequest, contracts.DataProcessorResponse]{
+		Path:   contracts.ProcessDataPath,
+		Method: http.MethodPost,
+	}
+	SupportedFormatsEndpoint = Endpoint[contracts.Empty, contracts.SupportedFormatsResponse]{
+		Path:   contracts.SupportedFormatsPath,
+		Method: http.MethodGet,
+	}
+	TransformEndpoint = Endpoint[contracts.TransformRequest, contracts.TransformResponse]{
+		Path:   contracts.TransformPath,
+		Method: http.MethodPost,
+	}
+	TransformationsEndpoint = Endpoint[contracts.Empty, contracts.TransformationsResponse]{
+		Path:   contracts.TransformationsPath,
+		Method: http.MethodGet,
+	}
+)
+
+var (
+	clientFactoriesMu sync.RWMutex
+	// clientFactories holds a constructor per contract interface that adapts an external plugin to it.
+	clientFactories = make(map[reflect.Type]func(*ExternalPluginWrapper) any)
+)
+
+// RegisterClient registers a constructor that adapts external plugins to the contract T.
+// Registering a constructor for a contract that already has one replaces it for plugins that
+// weren't adapted to the contract yet.
+func RegisterClient[T contracts.PluginBase](factory func(*ExternalPluginWrapper) T) {
+	clientFactoriesMu.Lock()
+	defer clientFactoriesMu.Unlock()
+
+	clientFactories[reflect.TypeFor[T]()] = func(w *ExternalPluginWrapper) any {
+		return factory(w)
+	}
+}
+
+// As returns the plugin as the contract T. Internal plugins are returned as they are if they
+// implement T; external plugins are adapted with the client registered for T. The client is
+// created once per plugin and contract and reused by later calls.
+func As[T contracts.PluginBase](plugin contracts.PluginBase) (T, error) {
+	var zero T
+
+	if typed, ok := plugin.(T); ok {
+		return typed, nil
+	}
+
+	wrapper, ok := plugin.(*ExternalPluginWrapper)
+	if !ok {
+		return zero, fmt.Errorf("plugin of type %T does not implement %s", plugin, reflect.TypeFor[T]())
+	}
+
+	contract := reflect.TypeFor[T]()
+	if client, ok := wrapper.clients.Load(contract); ok {
+		return client.(T), nil
+	}
+
+	clientFactoriesMu.RLock()
+	factory, ok := clientFactories[contract]
+	clientFactoriesMu.RUnlock()
+
+	if !ok {
+		return zero, fmt.Errorf("no client registered for contract %s", contract)
+	}
+
+	client, _ := wrapper.clients.LoadOrStore(contract, factory(wrapper))
+
+	return client.(T), nil
+}
+
+// GetTyped returns a plugin for the specified type as the contract T.
+func GetTyped[T contracts.PluginBase](ctx context.Context, r *Registry, pluginType string, opts ...GetOptionFn) (T, error) {
+	plugin, err := r.GetPlugin(ctx, pluginType, opts...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return As[T](plugin)
+}
+
+// GetTypedFor returns the plugin that declared the sub-type for the specified type as the contract T.
+func GetTypedFor[T contracts.PluginBase](ctx context.Context, r *Registry, pluginType, subType string) (T, error) {
+	plugin, err := r.GetPluginFor(ctx, pluginType, subType)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return As[T](plugin)
+}
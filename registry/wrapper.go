@@ -0,0 +1,220 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+	"github.com/Skarlso/go-plugin-framework/types"
+)
+
+// processState describes the lifecycle of an external plugin's process.
+type processState int
+
+const (
+	// stateNotStarted means the process has never been started.
+	stateNotStarted processState = iota
+	// stateRunning means the process is up and reachable.
+	stateRunning
+	// stateIdle means the process stopped itself after its idle timeout. It is started again on demand.
+	stateIdle
+	// stateExited means the process exited and will not be started on demand.
+	stateExited
+)
+
+// ExternalPluginWrapper wraps an external plugin to implement the PluginBase interface.
+// The wrapper stays valid across restarts of the plugin process; the connection details
+// are swapped in place whenever a new process is started.
+type ExternalPluginWrapper struct {
+	mu             sync.RWMutex
+	client         *http.Client
+	location       string
+	connectionType types.ConnectionType
+	plugin         *types.Plugin
+	state          processState
+	// authToken authenticates the calls to the plugin.
+	authToken string
+	// features holds the optional features negotiated with the current plugin process.
+	features []string
+	// exitErr holds the error the last process exited with.
+	exitErr error
+	// starter starts the plugin process if it isn't running and may be started on demand.
+	starter func(ctx context.Context) error
+	// schemas holds the compiled JSON schemas of the plugin's sub-types.
+	schemas typeSchemas
+	// inFlight counts the calls to the plugin that are currently in progress.
+	inFlight atomic.Int64
+	// clients caches the contract clients created for the plugin by As, keyed by contract type.
+	clients sync.Map
+	// generation counts the processes swapped in, so clients can tell when what they cached
+	// about the plugin came from an earlier process.
+	generation uint64
+}
+
+// Ping implements the PluginBase interface.
+func (w *ExternalPluginWrapper) Ping(ctx context.Context) error {
+	return w.CallPlugin(ctx, "/healthz", http.MethodGet)
+}
+
+// GetHTTPClient returns the HTTP client for making calls to the plugin.
+func (w *ExternalPluginWrapper) GetHTTPClient() *http.Client {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.client
+}
+
+// GetLocation returns the plugin's connection location.
+func (w *ExternalPluginWrapper) GetLocation() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.location
+}
+
+// GetID returns the plugin's ID.
+func (w *ExternalPluginWrapper) GetID() string {
+	return w.plugin.ID
+}
+
+// GetConnectionType returns the plugin's connection type.
+func (w *ExternalPluginWrapper) GetConnectionType() types.ConnectionType {
+	return w.connectionType
+}
+
+// HasFeature reports whether the optional feature was negotiated with the running plugin process.
+func (w *ExternalPluginWrapper) HasFeature(feature string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return slices.Contains(w.features, feature)
+}
+
+// CallPlugin makes an HTTP call to the plugin. If the plugin isn't running because it
+// was registered lazily or stopped after being idle, it is started first. With
+// plugins.WithSchemaValidation the payload is validated against the sub-type's schema
+// and rejected with a *SchemaError before anything is sent.
+func (w *ExternalPluginWrapper) CallPlugin(ctx context.Context, endpoint, method string, opts ...plugins.CallOptionFn) error {
+	options := &plugins.CallOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if subType := options.SchemaSubType; subType != "" {
+		schema, ok := w.schemas.find(options.SchemaType, subType)
+		if !ok {
+			return fmt.Errorf("plugin %s declared no JSON schema for type %q sub-type %q", w.plugin.ID, options.SchemaType, subType)
+		}
+
+		opts = append(opts, plugins.WithPayloadValidator(func(payload []byte) error {
+			return validatePayload(schema, subType, payload)
+		}))
+	}
+
+	if state, _ := w.status(); state != stateRunning && w.starter != nil {
+		if err := w.starter(ctx); err != nil {
+			return err
+		}
+	}
+
+	w.mu.RLock()
+	client, location, state, exitErr := w.client, w.location, w.state, w.exitErr
+	w.mu.RUnlock()
+
+	if state != stateRunning {
+		return w.exitedError(exitErr)
+	}
+
+	w.inFlight.Add(1)
+	defer w.inFlight.Add(-1)
+
+	opts = append(opts, plugins.WithAuthToken(w.authToken))
+
+	return plugins.Call(ctx, client, w.connectionType, location, endpoint, method, opts...)
+}
+
+// InFlight returns the number of calls to the plugin that are currently in progress.
+func (w *ExternalPluginWrapper) InFlight() int64 {
+	return w.inFlight.Load()
+}
+
+// exitedError creates the error returned for calls to a plugin whose process isn't running.
+func (w *ExternalPluginWrapper) exitedError(exitErr error) error {
+	if exitErr != nil {
+		return fmt.Errorf("plugin %s: %w: %w", w.plugin.ID, ErrPluginExited, exitErr)
+	}
+
+	return fmt.Errorf("plugin %s: %w", w.plugin.ID, ErrPluginExited)
+}
+
+// cmd returns the command of the current plugin process.
+func (w *ExternalPluginWrapper) cmd() *exec.Cmd {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.plugin.Cmd
+}
+
+// status returns the state of the plugin process and the error it last exited with.
+func (w *ExternalPluginWrapper) status() (processState, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.state, w.exitErr
+}
+
+// markExited records that the plugin process has stopped with the given state and error.
+func (w *ExternalPluginWrapper) markExited(state processState, exitErr error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.state = state
+	w.exitErr = exitErr
+}
+
+// swap replaces the connection details with the ones of a newly started process.
+func (w *ExternalPluginWrapper) swap(cmd *exec.Cmd, conn *plugins.Connection) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.swapLocked(cmd, conn)
+}
+
+// swapUnlessStopping swaps in a newly started process unless stopping is set, and reports whether
+// it did. Stopping the plugin sets stopping before it reads the state of the process under w.mu,
+// so either that sees the new process running or the process isn't swapped in.
+func (w *ExternalPluginWrapper) swapUnlessStopping(stopping *atomic.Bool, cmd *exec.Cmd, conn *plugins.Connection) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if stopping.Load() {
+		return false
+	}
+	w.swapLocked(cmd, conn)
+
+	return true
+}
+
+// swapLocked replaces the connection details. The caller has to hold w.mu.
+func (w *ExternalPluginWrapper) swapLocked(cmd *exec.Cmd, conn *plugins.Connection) {
+	w.plugin.Cmd = cmd
+	w.client = conn.Client
+	w.location = conn.Location
+	w.features = conn.Features
+	w.state = stateRunning
+	w.exitErr = nil
+	w.generation++
+}
+
+// processGeneration returns the number of processes swapped in so far.
+func (w *ExternalPluginWrapper) processGeneration() uint64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.generation
+}
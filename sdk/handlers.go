@@ -0,0 +1,107 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Skarlso/go-plugin-framework/contracts"
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+)
+
+// JSONHandler creates a handler that decodes the JSON request body into Req, calls fn and
+// writes its result as JSON. An empty body results in the zero value of Req. Errors are written
+// as plugins.Error; a *plugins.Error returned by fn keeps its status code if it has one, an
+// exceeded deadline results in a gateway timeout and any other error in an internal server error.
+func JSONHandler[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			plugins.NewError(fmt.Errorf("failed to decode request body: %w", err), http.StatusBadRequest).Write(w)
+			return
+		}
+
+		resp, err := fn(r.Context(), req)
+		if err != nil {
+			errorFor(err).Write(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			plugins.NewError(fmt.Errorf("failed to encode response: %w", err), http.StatusInternalServerError).Write(w)
+		}
+	}
+}
+
+// errorFor maps an error returned by a handler function onto a plugin error with a status code.
+// Plugin errors without a status code are internal server errors.
+func errorFor(err error) *plugins.Error {
+	var pluginErr *plugins.Error
+	switch {
+	case errors.As(err, &pluginErr) && pluginErr.StatusCode == 0:
+		return &plugins.Error{Message: pluginErr.Message, StatusCode: http.StatusInternalServerError}
+	case errors.As(err, &pluginErr):
+		return pluginErr
+	case errors.Is(err, context.DeadlineExceeded):
+		return plugins.NewError(err, http.StatusGatewayTimeout)
+	default:
+		return plugins.NewError(err, http.StatusInternalServerError)
+	}
+}
+
+// ServeDataProcessor returns the handlers that serve the canonical endpoints of
+// contracts.DataProcessor with the given implementation.
+func ServeDataProcessor(impl contracts.DataProcessor) []Handler {
+	return []Handler{
+		{
+			Location: http.MethodPost + " " + contracts.ProcessDataPath,
+			Handler: JSONHandler(func(ctx context.Context, req contracts.DataProcessorRequest) (contracts.DataProcessorResponse, error) {
+				data, err := impl.ProcessData(ctx, req.Data)
+				if err != nil {
+					return contracts.DataProcessorResponse{}, err
+				}
+
+				return contracts.DataProcessorResponse{Data: data, Format: req.Format}, nil
+			}),
+		},
+		{
+			Location: http.MethodGet + " " + contracts.SupportedFormatsPath,
+			Handler: JSONHandler(func(ctx context.Context, _ contracts.Empty) (contracts.SupportedFormatsResponse, error) {
+				formats, err := impl.GetSupportedFormats(ctx)
+				if err != nil {
+					return contracts.SupportedFormatsResponse{}, err
+				}
+
+				return contracts.SupportedFormatsResponse{Formats: formats}, nil
+			}),
+		},
+	}
+}
+
+// ServeTransformer returns the handlers that serve the canonical endpoints of
+// contracts.Transformer with the given implementation.
+func ServeTransformer(impl contracts.Transformer) []Handler {
+	return []Handler{
+		{
+			Location: http.MethodPost + " " + contracts.TransformPath,
+			Handler: JSONHandler(func(ctx context.Context, req contracts.TransformRequest) (*contracts.TransformResponse, error) {
+				return impl.Transform(ctx, &req)
+			}),
+		},
+		{
+			Location: http.MethodGet + " " + contracts.TransformationsPath,
+			Handler: JSONHandler(func(ctx context.Context, _ contracts.Empty) (contracts.TransformationsResponse, error) {
+				transformations, err := impl.GetTransformations(ctx)
+				if err != nil {
+					return contracts.TransformationsResponse{}, err
+				}
+
+				return contracts.TransformationsResponse{Transformations: transformations}, nil
+			}),
+		},
+	}
+}
@@ -0,0 +1,125 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Skarlso/go-plugin-framework/contracts"
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
+)
+
+type upperProcessor struct {
+	contracts.EmptyBasePlugin
+}
+
+func (*upperProcessor) ProcessData(_ context.Context, input []byte) ([]byte, error) {
+	if len(input) == 0 {
+		return nil, plugins.NewError(errors.New("no input given"), http.StatusUnprocessableEntity)
+	}
+
+	return []byte(strings.ToUpper(string(input))), nil
+}
+
+func (*upperProcessor) GetSupportedFormats(context.Context) ([]string, error) {
+	return nil, errors.New("formats are unavailable")
+}
+
+func newTestMux(t *testing.T, handlers []Handler) *http.ServeMux {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	for _, h := range handlers {
+		mux.HandleFunc(h.Location, h.Handler)
+	}
+
+	return mux
+}
+
+func TestServeDataProcessor(t *testing.T) {
+	mux := newTestMux(t, ServeDataProcessor(&upperProcessor{}))
+
+	body, err := json.Marshal(contracts.DataProcessorRequest{Data: []byte("hello"), Format: "text"})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/process", strings.NewReader(string(body))))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var response contracts.DataProcessorResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	require.Equal(t, "HELLO", string(response.Data))
+	require.Equal(t, "text", response.Format)
+
+	// Errors returned as plugin errors keep their status code
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/process", strings.NewReader(`{}`)))
+	require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "no input given")
+
+	// Any other error is an internal error
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/formats", nil))
+	require.Equal(t, http.StatusInternalServerError, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "formats are unavailable")
+
+	// Invalid bodies are rejected
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/process", strings.NewReader(`{"data": 1`)))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// Wrong methods are rejected
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/process", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	// Plugin errors without a status code are internal errors
+	recorder = httptest.NewRecorder()
+	JSONHandler(func(context.Context, contracts.Empty) (contracts.Empty, error) {
+		return contracts.Empty{}, &plugins.Error{Message: "no status"}
+	}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusInternalServerError, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "no status")
+}
+
+type reverseTransformer struct {
+	contracts.EmptyBasePlugin
+}
+
+func (*reverseTransformer) Transform(_ context.Context, request *contracts.TransformRequest) (*contracts.TransformResponse, error) {
+	data := []byte(request.Transformation)
+	slices.Reverse(data)
+
+	return &contracts.TransformResponse{Data: data}, nil
+}
+
+func (*reverseTransformer) GetTransformations(context.Context) ([]contracts.TransformationInfo, error) {
+	return []contracts.TransformationInfo{{Name: "reverse"}}, nil
+}
+
+func TestServeTransformer(t *testing.T) {
+	mux := newTestMux(t, ServeTransformer(&reverseTransformer{}))
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/transform", strings.NewReader(`{"transformation": "abc"}`)))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var response contracts.TransformResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	require.Equal(t, "cba", string(response.Data))
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/transformations", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var transformations contracts.TransformationsResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&transformations))
+	require.Equal(t, "reverse", transformations.Transformations[0].Name)
+}
@@ -2,6 +2,11 @@ package sdk
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +15,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"sync/atomic"
 	"syscall"
@@ -19,6 +25,18 @@ import (
 	"github.com/Skarlso/go-plugin-framework/types"
 )
 
+// Version is the version of the SDK that is reported to the manager in the handshake.
+const Version = "0.1.0"
+
+// ErrIdleTimeout is returned by Start when the plugin shut down because it was idle for
+// longer than its configured IdleTimeout. Plugin binaries should exit with types.IdleExitCode
+// in this case so the manager knows to start them again on demand.
+var ErrIdleTimeout = errors.New("plugin shut down after being idle")
+
+// ErrNoAuthToken is returned by Start when the plugin was launched by a manager but didn't
+// receive an auth token from it. The plugin refuses to serve without authentication then.
+var ErrNoAuthToken = errors.New("no auth token was passed by the manager")
+
 // Handler represents an HTTP handler for a plugin endpoint.
 type Handler struct {
 	Location string
@@ -32,16 +50,24 @@ type Handler struct {
 // it will reset this timer.
 type Plugin struct {
 	Config types.Config
+	// Capabilities are the capabilities the plugin declares. If set, their hash is sent in the
+	// handshake so the manager can verify that it registered the plugin with the same capabilities.
+	Capabilities *types.PluginCapabilities
+	// Features lists the optional handshake features the plugin supports.
+	Features []string
 
 	handlers      []Handler
 	server        *http.Server
 	interrupt     chan bool
 	workerCounter atomic.Int64
+	idle          atomic.Bool
 	location      string
 	output        io.Writer
 	baseCtx       context.Context
 	// this should be a logger using stderr instead of default logger.
 	logger slog.Logger
+	// secretsErr is the error parsing the secrets passed by the manager failed with.
+	secretsErr error
 }
 
 // NewPlugin creates a new Go based plugin. After creation,
@@ -49,13 +75,28 @@ type Plugin struct {
 // plugin's inner workings. A capabilities endpoint is automatically added
 // to every plugin. Takes an output device to print out the configure location
 // for the plugin to so that the manager can pick it up.
+//
+// The secret fields of conf that aren't set are read from the types.SecretsEnv environment
+// variable the manager passes them in, which is then removed so subprocesses don't inherit it.
+// If the secrets can't be parsed, Start returns the error.
 func NewPlugin(ctx context.Context, logger *slog.Logger, conf types.Config, output io.Writer) *Plugin {
+	var secretsErr error
+	if content, ok := os.LookupEnv(types.SecretsEnv); ok {
+		var secrets types.Secrets
+		if err := json.Unmarshal([]byte(content), &secrets); err != nil {
+			secretsErr = fmt.Errorf("failed to parse the secrets passed by the manager: %w", err)
+		}
+		conf = conf.MergeSecrets(secrets)
+		_ = os.Unsetenv(types.SecretsEnv)
+	}
+
 	return &Plugin{
-		Config:    conf,
-		interrupt: make(chan bool, 1), // to not block any new work coming in
-		output:    output,
-		baseCtx:   ctx, // base context is used for graceful shutdown operation to finish properly
-		logger:    *logger,
+		Config:     conf,
+		interrupt:  make(chan bool, 1), // to not block any new work coming in
+		output:     output,
+		baseCtx:    ctx, // base context is used for graceful shutdown operation to finish properly
+		logger:     *logger,
+		secretsErr: secretsErr,
 	}
 }
 
@@ -72,6 +113,7 @@ func (p *Plugin) startIdleChecker(ctx context.Context) {
 		case <-timer.C:
 			timer.Stop()
 
+			p.idle.Store(true)
 			if err := p.GracefulShutdown(ctx); err != nil {
 				p.logger.ErrorContext(ctx, "failed to gracefully shutdown plugin", "error", err)
 			}
@@ -110,8 +152,19 @@ func (p *Plugin) StopWork() {
 }
 
 // Start starts the plugin and sets up a graceful shutdown catch for interrupts.
-// The Context here is created in the plugin binary.
+// The Context here is created in the plugin binary. Start returns nil once the plugin was
+// shut down and ErrIdleTimeout if it shut down because it was idle. A plugin launched by a
+// manager, which sets Config.ManagerPID, doesn't start without an auth token and returns
+// ErrNoAuthToken.
 func (p *Plugin) Start(ctx context.Context) error {
+	if p.secretsErr != nil {
+		return p.secretsErr
+	}
+
+	if p.Config.AuthToken == "" && p.Config.ManagerPID != 0 {
+		return ErrNoAuthToken
+	}
+
 	// Handle graceful shutdown on SIGINT/SIGTERM
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -155,11 +208,30 @@ func (p *Plugin) listen(ctx context.Context) error {
 	}
 	p.location = loc
 
-	conn, err := net.Listen(string(p.Config.Type), loc)
+	network := string(p.Config.Type)
+	if p.Config.Type == types.AbstractSocket {
+		// Abstract sockets are Unix sockets whose name starts with @.
+		network = "unix"
+	}
+
+	conn, err := net.Listen(network, loc)
 	if err != nil {
 		return fmt.Errorf("failed to connect to socket from client: %w", err)
 	}
 
+	if (p.Config.Type == types.Socket || p.Config.Type == types.AbstractSocket) && p.Config.ManagerPID != 0 {
+		conn = &peerListener{Listener: conn, managerPID: p.Config.ManagerPID, logger: &p.logger}
+	}
+
+	if p.Config.Type == types.TCP && p.Config.TLS != nil {
+		tlsConfig, err := serverTLSConfig(p.Config.TLS)
+		if err != nil {
+			_ = conn.Close()
+			return err
+		}
+		conn = tls.NewListener(conn, tlsConfig)
+	}
+
 	m := http.NewServeMux()
 	for _, h := range p.handlers {
 		m.HandleFunc(h.Location, p.panicRecovery(h.Handler))
@@ -168,10 +240,16 @@ func (p *Plugin) listen(ctx context.Context) error {
 	m.HandleFunc("/shutdown", p.Shutdown)
 	m.HandleFunc("/healthz", p.Healthz)
 
+	if p.Config.AuthToken == "" {
+		p.logger.WarnContext(ctx, "no auth token configured, the plugin accepts requests from anyone who can reach it", "id", p.Config.ID)
+	}
+
 	server := &http.Server{
-		Handler:           m,
+		Handler:           p.authenticate(m),
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       15 * time.Second,
+		// Failed TLS handshakes and other connection errors are logged like the rest of the plugin's logs.
+		ErrorLog: slog.NewLogLogger(p.logger.Handler(), slog.LevelWarn),
 		BaseContext: func(listener net.Listener) context.Context {
 			return ctx
 		},
@@ -182,20 +260,139 @@ func (p *Plugin) listen(ctx context.Context) error {
 
 	p.server = server
 
-	// output the location before starting the server
+	// output the handshake before starting the server
+	if err := p.writeHandshake(loc); err != nil {
+		return err
+	}
+
+	if err := server.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	if p.idle.Load() {
+		return ErrIdleTimeout
+	}
+
+	return nil
+}
+
+// writeHandshake writes the handshake line that tells the manager where and how to reach the plugin.
+func (p *Plugin) writeHandshake(loc string) error {
 	var schemedLocation string
 	switch p.Config.Type {
 	case types.TCP:
-		schemedLocation = loc // http is already included in tcp output
-	case types.Socket:
+		schemedLocation = "http://" + loc
+		if p.Config.TLS != nil {
+			schemedLocation = "https://" + loc
+		}
+	case types.Socket, types.AbstractSocket:
 		schemedLocation = "http+unix://" + loc
 	}
 
-	if _, err := fmt.Fprintln(p.output, schemedLocation); err != nil {
-		return fmt.Errorf("failed to write location to output writer: %w", err)
+	transports := []types.ConnectionType{types.TCP, types.Socket}
+	if plugins.AbstractSocketsSupported {
+		transports = append(transports, types.AbstractSocket)
+	}
+
+	handshake := types.Handshake{
+		ProtocolVersion: types.ProtocolVersion,
+		SDKVersion:      Version,
+		Location:        schemedLocation,
+		PID:             os.Getpid(),
+		Transports:      transports,
+		Features:        p.Features,
+	}
+
+	if p.Config.Type == types.TCP && p.Config.TLS != nil && !slices.Contains(handshake.Features, types.FeatureTLS) {
+		handshake.Features = append(slices.Clone(handshake.Features), types.FeatureTLS)
+	}
+
+	if p.Capabilities != nil {
+		hash, err := types.HashCapabilities(*p.Capabilities)
+		if err != nil {
+			return err
+		}
+		handshake.CapabilitiesHash = hash
 	}
 
-	return server.Serve(conn)
+	content, err := json.Marshal(handshake)
+	if err != nil {
+		return fmt.Errorf("failed to marshal handshake: %w", err)
+	}
+
+	if _, err := fmt.Fprintln(p.output, types.HandshakePrefix+string(content)); err != nil {
+		return fmt.Errorf("failed to write handshake to output writer: %w", err)
+	}
+
+	return nil
+}
+
+// serverTLSConfig creates the configuration a TCP plugin serves mutual TLS with. Only clients
+// with a certificate issued by the client CA can connect.
+func serverTLSConfig(conf *types.TLSConfig) (*tls.Config, error) {
+	cert, err := tls.X509KeyPair([]byte(conf.Cert), []byte(conf.Key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS certificate or key: %w", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM([]byte(conf.ClientCA)) {
+		return nil, errors.New("invalid TLS client CA")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// peerListener only accepts connections from the manager process that started the plugin. The
+// process of a connection is checked with its peer credentials where they are supported.
+type peerListener struct {
+	net.Listener
+	managerPID int
+	logger     *slog.Logger
+}
+
+// Accept implements net.Listener. Connections from other processes are closed right away.
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		pid, _, err := plugins.PeerCredentials(conn)
+		switch {
+		case errors.Is(err, errors.ErrUnsupported):
+			return conn, nil
+		case err != nil:
+			l.logger.Warn("rejected connection without peer credentials", "error", err)
+		case pid != l.managerPID:
+			l.logger.Warn("rejected connection from a process other than the manager", "pid", pid, "manager-pid", l.managerPID)
+		default:
+			return conn, nil
+		}
+
+		_ = conn.Close()
+	}
+}
+
+// authenticate rejects requests that don't carry the auth token the plugin was configured with.
+// Every endpoint is protected, including /healthz and /shutdown.
+func (p *Plugin) authenticate(next http.Handler) http.Handler {
+	expected := []byte(types.AuthScheme + " " + p.Config.AuthToken)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.Config.AuthToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			plugins.NewError(errors.New("missing or invalid auth token"), http.StatusUnauthorized).Write(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
 }
 
 func (p *Plugin) panicRecovery(f func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
@@ -217,7 +414,16 @@ func (p *Plugin) panicRecovery(f func(w http.ResponseWriter, r *http.Request)) h
 func (p *Plugin) determineLocation() (_ string, err error) {
 	switch p.Config.Type {
 	case types.Socket:
-		loc := "/tmp/" + p.Config.ID + "-plugin.socket"
+		dir := p.Config.RuntimeDir
+		if dir == "" {
+			dir = "/tmp"
+		}
+
+		loc, err := plugins.SocketPath(dir, p.Config.ID, p.Config.Instance)
+		if err != nil {
+			return "", err
+		}
+
 		if _, err := os.Stat(loc); err == nil {
 			if cleanupErr := p.performCleanUp(loc); cleanupErr != nil {
 				return "", fmt.Errorf("could not cleanup socket: %w", cleanupErr)
@@ -229,11 +435,19 @@ func (p *Plugin) determineLocation() (_ string, err error) {
 		}
 
 		return loc, nil
+	case types.AbstractSocket:
+		if !plugins.AbstractSocketsSupported {
+			return "", errors.New("abstract sockets are only supported on Linux")
+		}
+
+		// Abstract sockets have no file permissions or lock file, so the random suffix keeps other
+		// processes from taking the name first. The name is sent to the manager in the handshake.
+		return "@" + plugins.SocketName(p.Config.ID, p.Config.Instance) + "-" + rand.Text(), nil
 	case types.TCP:
-		// Listen `:0` gives back a random _free_ port for the plugin to listen on.
-		// Once we have this port, this listener is immediately closed and a purpose listener
-		// will be opened with the specific port.
-		loc, err := net.Listen("tcp", ":0") //nolint: gosec // G102: only does it temporarily to find an empty address
+		// Listen `127.0.0.1:0` gives back a random _free_ port on the loopback interface for the
+		// plugin to listen on, so it can't be reached from other machines. Once we have this port,
+		// this listener is immediately closed and a purpose listener will be opened with the specific port.
+		loc, err := net.Listen("tcp", "127.0.0.1:0")
 		if err != nil {
 			return "", fmt.Errorf("failed to start tcp listener: %w", err)
 		}
@@ -268,6 +482,8 @@ func (p *Plugin) GracefulShutdown(ctx context.Context) error {
 		if err := os.Remove(p.location + ".lock"); err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
+	case types.AbstractSocket:
+		// abstract sockets are gone once the listener is closed
 	case types.TCP:
 		// empty case for now
 	}
@@ -306,12 +522,19 @@ func (p *Plugin) workerHandler(h http.HandlerFunc) http.HandlerFunc {
 // needs to use baseContext that is provided during plugin creation instead of request context
 // because otherwise, the shutdown is interrupted by the request context being cancelled mid-shutdown
 // resulting in a context cancelled error instead of properly closing connection to the server.
+// The response is sent before shutting down, since the server waits for this request to finish.
 func (p *Plugin) Shutdown(w http.ResponseWriter, _ *http.Request) {
 	p.logger.InfoContext(p.baseCtx, "Shutting down plugin", "id", p.Config.ID)
 	w.WriteHeader(http.StatusOK)
-	if err := p.GracefulShutdown(p.baseCtx); err != nil {
-		p.logger.ErrorContext(p.baseCtx, "Error shutting down plugin", "error", err)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
 	}
+
+	go func() {
+		if err := p.GracefulShutdown(p.baseCtx); err != nil {
+			p.logger.ErrorContext(p.baseCtx, "Error shutting down plugin", "error", err)
+		}
+	}()
 }
 
 // performCleanUp looks for a lock file that contains the pid of the process using the corresponding socket file.
@@ -1,14 +1,21 @@
 package sdk
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/Skarlso/go-plugin-framework/registry/plugins"
 	"github.com/Skarlso/go-plugin-framework/types"
 )
 
@@ -82,3 +89,255 @@ func TestPluginIdleTimeout(t *testing.T) {
 	// but we can at least verify the configuration is set
 	require.Equal(t, &shortTimeout, plugin.Config.IdleTimeout)
 }
+
+func TestPluginHandshake(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	config := types.Config{
+		ID:   "test-plugin",
+		Type: types.Socket,
+	}
+
+	output := &bytes.Buffer{}
+	plugin := NewPlugin(context.Background(), logger, config, output)
+	plugin.Capabilities = &types.PluginCapabilities{
+		Types: map[string][]types.TypeInfo{"dataProcessor": {{Type: "test"}}},
+	}
+
+	require.NoError(t, plugin.writeHandshake("/tmp/test-plugin.socket"))
+
+	line, ok := strings.CutPrefix(output.String(), types.HandshakePrefix)
+	require.True(t, ok)
+
+	handshake, err := plugins.ParseHandshake(line, types.Socket)
+	require.NoError(t, err)
+	require.Equal(t, "http+unix:///tmp/test-plugin.socket", handshake.Location)
+	require.Equal(t, Version, handshake.SDKVersion)
+	require.Equal(t, os.Getpid(), handshake.PID)
+
+	hash, err := types.HashCapabilities(*plugin.Capabilities)
+	require.NoError(t, err)
+	require.Equal(t, hash, handshake.CapabilitiesHash)
+}
+
+func TestPluginSocketInstance(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	plugin := NewPlugin(context.Background(), logger, types.Config{
+		ID:       "test-instance-plugin",
+		Type:     types.Socket,
+		Instance: "2",
+	}, &bytes.Buffer{})
+
+	loc, err := plugin.determineLocation()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.Remove(loc + ".lock")
+	})
+	require.Equal(t, filepath.Join("/tmp", plugins.SocketName("test-instance-plugin", "2")+".sock"), loc)
+	require.NotEqual(t, plugins.SocketName("test-instance-plugin", ""), plugins.SocketName("test-instance-plugin", "2"))
+}
+
+func TestPluginSocketRuntimeDir(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	dir := t.TempDir()
+	plugin := NewPlugin(context.Background(), logger, types.Config{
+		ID:         "test-runtime-dir-plugin",
+		Type:       types.Socket,
+		RuntimeDir: dir,
+	}, &bytes.Buffer{})
+
+	loc, err := plugin.determineLocation()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, plugins.SocketName("test-runtime-dir-plugin", "")+".sock"), loc)
+	require.FileExists(t, loc+".lock")
+
+	// Runtime directories with paths too long for a socket address are refused.
+	plugin.Config.RuntimeDir = filepath.Join(dir, strings.Repeat("d", plugins.MaxSocketPathLength))
+	_, err = plugin.determineLocation()
+	require.ErrorIs(t, err, plugins.ErrSocketPathTooLong)
+}
+
+func TestPluginTCPAuthentication(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	config := types.Config{
+		ID:   "test-auth-plugin",
+		Type: types.TCP,
+	}
+
+	// The manager passes the token in the environment instead of the command line.
+	t.Setenv(types.SecretsEnv, `{"authToken":"secret"}`)
+	stdout, output := io.Pipe()
+	plugin := NewPlugin(context.Background(), logger, config, output)
+	require.Equal(t, "secret", plugin.Config.AuthToken)
+	_, ok := os.LookupEnv(types.SecretsEnv)
+	require.False(t, ok)
+	config.AuthToken = "secret"
+
+	started := make(chan error, 1)
+	go func() {
+		started <- plugin.Start(context.Background())
+	}()
+
+	conn, err := plugins.WaitForPlugin(t.Context(), &types.Plugin{ID: config.ID, Config: config}, stdout)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(conn.Location, "http://127.0.0.1:"), conn.Location)
+
+	// Every endpoint rejects requests without the token.
+	for _, endpoint := range []string{"/healthz", "/shutdown"} {
+		err := plugins.Call(t.Context(), conn.Client, types.TCP, conn.Location, endpoint, http.MethodGet)
+		require.ErrorContains(t, err, "status code 401")
+
+		err = plugins.Call(t.Context(), conn.Client, types.TCP, conn.Location, endpoint, http.MethodGet, plugins.WithAuthToken("wrong"))
+		require.ErrorContains(t, err, "status code 401")
+	}
+
+	require.NoError(t, plugins.Call(t.Context(), conn.Client, types.TCP, conn.Location, "/shutdown", http.MethodPost, plugins.WithAuthToken("secret")))
+	require.NoError(t, <-started)
+}
+
+func TestPluginRequiresAuthTokenFromManager(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	// A plugin launched by a manager doesn't serve without authentication.
+	config := types.Config{ID: "test-auth-plugin", Type: types.TCP, ManagerPID: os.Getpid()}
+	plugin := NewPlugin(context.Background(), logger, config, io.Discard)
+	require.ErrorIs(t, plugin.Start(context.Background()), ErrNoAuthToken)
+
+	// Secrets that can't be parsed fail the start as well.
+	t.Setenv(types.SecretsEnv, `{"authToken":`)
+	config.ManagerPID = 0
+	plugin = NewPlugin(context.Background(), logger, config, io.Discard)
+	require.ErrorContains(t, plugin.Start(context.Background()), "failed to parse the secrets passed by the manager")
+}
+
+func TestPluginTLS(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	ca, err := plugins.NewCertificateAuthority()
+	require.NoError(t, err)
+	tlsConfig, pinned, err := ca.IssuePluginCertificate("test-tls-plugin")
+	require.NoError(t, err)
+
+	config := types.Config{
+		ID:        "test-tls-plugin",
+		Type:      types.TCP,
+		AuthToken: "secret",
+		TLS:       tlsConfig,
+	}
+
+	stdout, output := io.Pipe()
+	plugin := NewPlugin(context.Background(), logger, config, output)
+	started := make(chan error, 1)
+	go func() {
+		started <- plugin.Start(context.Background())
+	}()
+
+	conn, err := plugins.WaitForPlugin(t.Context(), &types.Plugin{ID: config.ID, Config: config}, stdout, plugins.WithTLS(ca.ClientCertificate(), pinned))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(conn.Location, "https://127.0.0.1:"), conn.Location)
+	require.Equal(t, []string{types.FeatureTLS}, conn.Features)
+
+	// Clients without a certificate issued by the CA are refused.
+	err = plugins.Call(t.Context(), &http.Client{}, types.TCP, conn.Location, "/healthz", http.MethodGet, plugins.WithAuthToken("secret"))
+	require.Error(t, err)
+
+	// Clients only accept the certificate that was issued for the plugin.
+	_, otherCert, err := ca.IssuePluginCertificate("other-plugin")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	_, err = plugins.WaitForPlugin(ctx, &types.Plugin{ID: config.ID, Config: config},
+		strings.NewReader(types.HandshakePrefix+`{"protocolVersion":"1.0.0","location":"`+conn.Location+`","transports":["tcp"]}`+"\n"),
+		plugins.WithTLS(ca.ClientCertificate(), otherCert))
+	require.ErrorContains(t, err, "plugin did not present the certificate issued for it")
+
+	require.NoError(t, plugins.Call(t.Context(), conn.Client, types.TCP, conn.Location, "/shutdown", http.MethodPost, plugins.WithAuthToken("secret")))
+	require.NoError(t, <-started)
+}
+
+func TestPluginSocketPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only checked on Linux")
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	for _, managerPID := range []int{1, os.Getpid()} {
+		config := types.Config{
+			ID:         "test-peer-plugin",
+			Type:       types.Socket,
+			AuthToken:  "secret",
+			ManagerPID: managerPID,
+		}
+
+		stdout, output := io.Pipe()
+		plugin := NewPlugin(context.Background(), logger, config, output)
+		started := make(chan error, 1)
+		go func() {
+			started <- plugin.Start(context.Background())
+		}()
+
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		_, err := plugins.WaitForPlugin(ctx, &types.Plugin{ID: config.ID, Config: config}, stdout)
+		cancel()
+
+		// Only the manager that started the plugin can connect.
+		if managerPID == os.Getpid() {
+			require.NoError(t, err)
+		} else {
+			require.ErrorIs(t, err, plugins.ErrNotReady)
+		}
+
+		require.NoError(t, plugin.GracefulShutdown(t.Context()))
+		require.NoError(t, <-started)
+	}
+}
+
+func TestPluginAbstractSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are only supported on Linux")
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelError,
+	}))
+
+	config := types.Config{
+		ID:         "test-abstract-plugin",
+		Type:       types.AbstractSocket,
+		AuthToken:  "secret",
+		ManagerPID: os.Getpid(),
+	}
+
+	stdout, output := io.Pipe()
+	plugin := NewPlugin(context.Background(), logger, config, output)
+	started := make(chan error, 1)
+	go func() {
+		started <- plugin.Start(context.Background())
+	}()
+
+	conn, err := plugins.WaitForPlugin(t.Context(), &types.Plugin{ID: config.ID, Config: config}, stdout)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(conn.Location, "http+unix://@"+plugins.SocketName("test-abstract-plugin", "")+"-"), conn.Location)
+	require.Contains(t, conn.Handshake.Transports, types.AbstractSocket)
+
+	require.NoError(t, plugins.Call(t.Context(), conn.Client, types.AbstractSocket, conn.Location, "/shutdown", http.MethodPost, plugins.WithAuthToken("secret")))
+	require.NoError(t, <-started)
+}
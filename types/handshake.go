@@ -0,0 +1,101 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ProtocolVersion is the version of the handshake protocol spoken between the manager and plugins.
+// Plugins and managers are compatible as long as the major versions match.
+const ProtocolVersion = "1.0.0"
+
+// HandshakePrefix starts the handshake line a plugin writes to stdout. It tells the handshake apart
+// from log output the plugin writes before it.
+const HandshakePrefix = "PLUGIN_HANDSHAKE:"
+
+// Optional features a plugin can declare in its handshake. A feature is only used if both sides support it.
+const (
+	// FeatureStreaming marks that the plugin can stream responses.
+	FeatureStreaming = "streaming"
+	// FeatureTLS marks that the plugin serves its endpoints over TLS.
+	FeatureTLS = "tls"
+)
+
+// Handshake is written by a plugin as a single JSON line, prefixed with HandshakePrefix, to stdout
+// once it's ready to accept connections.
+type Handshake struct {
+	// ProtocolVersion is the handshake protocol version the plugin speaks.
+	ProtocolVersion string `json:"protocolVersion"`
+	// SDKVersion is the version of the SDK the plugin was built with, if any.
+	SDKVersion string `json:"sdkVersion,omitempty"`
+	// Location is where the plugin can be reached.
+	Location string `json:"location"`
+	// PID is the process ID of the plugin.
+	PID int `json:"pid"`
+	// Transports lists the connection types the plugin supports.
+	Transports []ConnectionType `json:"transports"`
+	// CapabilitiesHash is the hash of the capabilities the plugin serves, see HashCapabilities.
+	CapabilitiesHash string `json:"capabilitiesHash,omitempty"`
+	// Features lists the optional features the plugin supports.
+	Features []string `json:"features,omitempty"`
+}
+
+// HashCapabilities returns a stable hash of the capabilities which is used to verify that a running
+// plugin serves the capabilities the manager registered it with. Types, config types and the
+// formatting of the JSON schemas don't affect the hash, so capabilities declared in a manifest
+// hash the same as the ones the plugin binary reports.
+func HashCapabilities(capabilities PluginCapabilities) (string, error) {
+	canonical := PluginCapabilities{
+		Types:       make(map[string][]TypeInfo, len(capabilities.Types)),
+		ConfigTypes: slices.Sorted(slices.Values(capabilities.ConfigTypes)),
+	}
+
+	for pluginType, infos := range capabilities.Types {
+		sorted := make([]TypeInfo, 0, len(infos))
+		for _, info := range infos {
+			schema, err := canonicalJSON(info.JSONSchema)
+			if err != nil {
+				return "", fmt.Errorf("invalid JSON schema for type %q sub-type %q: %w", pluginType, info.Type, err)
+			}
+
+			sorted = append(sorted, TypeInfo{Type: info.Type, JSONSchema: schema})
+		}
+
+		slices.SortFunc(sorted, func(a, b TypeInfo) int {
+			return strings.Compare(a.Type, b.Type)
+		})
+		canonical.Types[pluginType] = sorted
+	}
+
+	// maps are serialized with sorted keys, which keeps the hash stable.
+	content, err := json.Marshal(canonical)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal capabilities: %w", err)
+	}
+
+	sum := sha256.Sum256(content)
+
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalJSON re-encodes a JSON document with sorted keys and without insignificant whitespace.
+func canonicalJSON(content []byte) ([]byte, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
@@ -0,0 +1,113 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ManifestSuffixes are the suffixes of manifest files, in the order they are looked up.
+// The manifest of the plugin binary ./plugins/my-plugin is ./plugins/my-plugin.plugin.json,
+// ./plugins/my-plugin.plugin.yaml or ./plugins/my-plugin.plugin.yml.
+var ManifestSuffixes = []string{".plugin.json", ".plugin.yaml", ".plugin.yml"}
+
+// Manifest describes a plugin next to its binary so the manager doesn't have to execute the
+// binary to learn its capabilities.
+type Manifest struct {
+	// ID overrides the plugin ID, which defaults to the name of the binary.
+	ID string `json:"id,omitempty"`
+	// Version is the version of the plugin.
+	Version string `json:"version,omitempty"`
+	// Types define a plugin type specific list of types that the plugin supports.
+	Types map[string][]ManifestType `json:"types"`
+	// ConfigTypes define a list of configuration types the plugin understands.
+	ConfigTypes []string `json:"configTypes,omitempty"`
+	// Env lists the environment variables that have to be set for the plugin to work.
+	Env []string `json:"env,omitempty"`
+	// Resources holds the resource limits the plugin process should run with.
+	Resources *ResourceLimits `json:"resources,omitempty"`
+}
+
+// ManifestType is a TypeInfo whose JSON schema is written inline instead of as encoded bytes.
+type ManifestType struct {
+	// Type defines the type name that this plugin supports.
+	Type string `json:"type"`
+	// JSONSchema holds the schema for the type.
+	JSONSchema json.RawMessage `json:"jsonSchema,omitempty"`
+}
+
+// ResourceLimits constrains the resources a plugin process may use. Zero values mean no limit.
+type ResourceLimits struct {
+	// MemoryBytes is the maximum memory of the plugin and its subprocesses in bytes. It's only
+	// applied in a cgroup (memory.max).
+	MemoryBytes uint64 `json:"memoryBytes,omitempty"`
+	// AddressSpaceBytes is the maximum size of the virtual address space of the process in bytes
+	// (RLIMIT_AS). It's rounded down to KiB. The address space is usually much larger than the
+	// memory a process uses, so it only guards against runaway allocations.
+	AddressSpaceBytes uint64 `json:"addressSpaceBytes,omitempty"`
+	// OpenFiles is the maximum number of open file descriptors (RLIMIT_NOFILE).
+	OpenFiles uint64 `json:"openFiles,omitempty"`
+	// CPUSeconds is the maximum CPU time of the process in seconds (RLIMIT_CPU).
+	CPUSeconds uint64 `json:"cpuSeconds,omitempty"`
+	// CPUs is the number of CPUs the plugin may use at most, for example 0.5. It's only applied in a cgroup (cpu.max).
+	CPUs float64 `json:"cpus,omitempty"`
+	// Processes is the maximum number of processes and threads of the plugin. It's only applied in a cgroup (pids.max).
+	Processes uint64 `json:"processes,omitempty"`
+}
+
+// Merge returns the stricter of both limits for every resource. Either of them may be nil.
+func (l *ResourceLimits) Merge(other *ResourceLimits) *ResourceLimits {
+	switch {
+	case l == nil:
+		return other
+	case other == nil:
+		return l
+	}
+
+	return &ResourceLimits{
+		MemoryBytes:       stricter(l.MemoryBytes, other.MemoryBytes),
+		AddressSpaceBytes: stricter(l.AddressSpaceBytes, other.AddressSpaceBytes),
+		OpenFiles:         stricter(l.OpenFiles, other.OpenFiles),
+		CPUSeconds:        stricter(l.CPUSeconds, other.CPUSeconds),
+		CPUs:              stricter(l.CPUs, other.CPUs),
+		Processes:         stricter(l.Processes, other.Processes),
+	}
+}
+
+// stricter returns the smaller limit, where zero means no limit.
+func stricter[T uint64 | float64](a, b T) T {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	}
+
+	return min(a, b)
+}
+
+// Capabilities returns the capabilities declared by the manifest.
+func (m *Manifest) Capabilities() (*PluginCapabilities, error) {
+	if len(m.Types) == 0 {
+		return nil, fmt.Errorf("manifest declares no types")
+	}
+
+	capabilities := &PluginCapabilities{
+		Types:       make(map[string][]TypeInfo, len(m.Types)),
+		ConfigTypes: m.ConfigTypes,
+	}
+
+	for pluginType, types := range m.Types {
+		for _, t := range types {
+			if t.Type == "" {
+				return nil, fmt.Errorf("manifest declares a sub-type without a name for type %q", pluginType)
+			}
+
+			capabilities.Types[pluginType] = append(capabilities.Types[pluginType], TypeInfo{
+				Type:       t.Type,
+				JSONSchema: []byte(t.JSONSchema),
+			})
+		}
+	}
+
+	return capabilities, nil
+}
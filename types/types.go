@@ -11,8 +11,18 @@ type ConnectionType string
 const (
 	TCP    ConnectionType = "tcp"
 	Socket ConnectionType = "unix"
+	// AbstractSocket is a Unix socket in the Linux abstract namespace. Its name starts with @ and
+	// it has no file that has to be cleaned up.
+	AbstractSocket ConnectionType = "abstract"
 )
 
+// IdleExitCode is the exit code a plugin process uses when it stopped because it was idle
+// for longer than its configured IdleTimeout. The manager starts such plugins again on demand.
+const IdleExitCode = 3
+
+// AuthScheme is the scheme of the Authorization header that carries the AuthToken of a plugin.
+const AuthScheme = "Bearer"
+
 // Config holds the configuration for a plugin.
 type Config struct {
 	// ID is a unique identifier for the plugin instance.
@@ -23,6 +33,61 @@ type Config struct {
 	IdleTimeout *time.Duration `json:"idleTimeout,omitempty"`
 	// ConfigTypes holds configuration data passed to the plugin during startup.
 	ConfigTypes []ConfigData `json:"configTypes,omitempty"`
+	// Instance distinguishes processes of the same plugin that run at the same time, for example
+	// while a plugin is replaced. Plugins include it in the names of the files they create.
+	Instance string `json:"instance,omitempty"`
+	// AuthToken is the secret the manager sends with every request to the plugin, see AuthScheme.
+	// Plugins reject requests without it. The manager passes it in SecretsEnv.
+	AuthToken string `json:"authToken,omitempty"`
+	// TLS makes a TCP plugin serve over mutual TLS. The manager passes it in SecretsEnv.
+	TLS *TLSConfig `json:"tls,omitempty"`
+	// ManagerPID is the process ID of the manager that started the plugin. Plugins served over a
+	// Unix socket only accept connections from it where the peer credentials can be checked.
+	ManagerPID int `json:"managerPID,omitempty"`
+	// RuntimeDir is the private directory the plugin creates its socket and lock file in. Plugins
+	// use the temporary directory if it's not set.
+	RuntimeDir string `json:"runtimeDir,omitempty"`
+}
+
+// TLSConfig holds the PEM encoded certificates and key a TCP plugin serves mutual TLS with.
+type TLSConfig struct {
+	// Cert is the certificate the plugin serves.
+	Cert string `json:"cert"`
+	// Key is the private key of Cert.
+	Key string `json:"key"`
+	// ClientCA is the certificate authority client certificates have to be issued by.
+	ClientCA string `json:"clientCA"`
+}
+
+// SecretsEnv is the environment variable the manager passes the secret fields of Config in as JSON,
+// so they don't show up in the command line of the plugin process, which other users can read.
+const SecretsEnv = "PLUGIN_SECRETS"
+
+// Secrets holds the secret fields of Config.
+type Secrets struct {
+	AuthToken string     `json:"authToken,omitempty"`
+	TLS       *TLSConfig `json:"tls,omitempty"`
+}
+
+// SplitSecrets returns the configuration without its secret fields, and the secret fields.
+func (c Config) SplitSecrets() (Config, Secrets) {
+	secrets := Secrets{AuthToken: c.AuthToken, TLS: c.TLS}
+	c.AuthToken = ""
+	c.TLS = nil
+
+	return c, secrets
+}
+
+// MergeSecrets sets the secret fields of the configuration that aren't set yet.
+func (c Config) MergeSecrets(secrets Secrets) Config {
+	if c.AuthToken == "" {
+		c.AuthToken = secrets.AuthToken
+	}
+	if c.TLS == nil {
+		c.TLS = secrets.TLS
+	}
+
+	return c
 }
 
 // ConfigData represents a single configuration item.
@@ -43,6 +108,19 @@ type Plugin struct {
 	Cmd *exec.Cmd
 	// Types holds the plugin's declared capabilities.
 	Types map[string][]TypeInfo
+	// CapabilitiesHash is the hash of the declared capabilities, see HashCapabilities.
+	// If set, it's compared against the hash the running plugin reports in its handshake.
+	CapabilitiesHash string
+	// Version is the plugin version declared in its manifest.
+	Version string
+	// Manifest is the path of the manifest the plugin was registered from. Plugins registered
+	// from a manifest have to report a capabilities hash in their handshake.
+	Manifest string
+	// Resources holds the resource limits declared for the plugin.
+	Resources *ResourceLimits
+	// Checksum is the verified checksum of the plugin binary in the form "sha256:<hex>". If set,
+	// the binary is checked against it every time before the plugin process is started.
+	Checksum string
 }
 
 // TypeInfo defines a plugin's supported type and its JSON schema.